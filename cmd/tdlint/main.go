@@ -0,0 +1,124 @@
+// Command tdlint check the files written by TDLogConsumer before they are uploaded, so that the data
+// rejected by the receiver is found early. It reads stdin when no file is given.
+//
+//	tdlint /var/log/ta/log.2023-01-01
+//	tdlint -json /var/log/ta/log.* > report.json
+//
+// The exit status is 1 when any issue is found.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+)
+
+// maxLineSize the max size of a line, TDLogConsumer writes one data per line
+const maxLineSize = 16 * 1024 * 1024
+
+type fileReport struct {
+	File   string                   `json:"file"`
+	Lines  int                      `json:"lines"`
+	Issues []thinkingdata.LintIssue `json:"issues"`
+	Error  string                   `json:"error,omitempty"`
+}
+
+type report struct {
+	Files       []*fileReport `json:"files"`
+	TotalLines  int           `json:"total_lines"`
+	TotalIssues int           `json:"total_issues"`
+}
+
+func main() {
+	var jsonOutput bool
+	flag.BoolVar(&jsonOutput, "json", false, "print the report in JSON")
+	flag.Parse()
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	linter := thinkingdata.NewLinter()
+	var r report
+	for _, name := range files {
+		fr := lintFile(linter, name)
+		r.Files = append(r.Files, fr)
+		r.TotalLines += fr.Lines
+		r.TotalIssues += len(fr.Issues)
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		_ = encoder.Encode(r)
+	} else {
+		printReport(&r)
+	}
+
+	for _, fr := range r.Files {
+		if len(fr.Error) > 0 {
+			os.Exit(2)
+		}
+	}
+	if r.TotalIssues > 0 {
+		os.Exit(1)
+	}
+}
+
+func lintFile(linter *thinkingdata.TDLinter, name string) *fileReport {
+	fr := &fileReport{File: name, Issues: []thinkingdata.LintIssue{}}
+	var reader io.Reader = os.Stdin
+	if name == "-" {
+		fr.File = "<stdin>"
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			fr.Error = err.Error()
+			return fr
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		fr.Lines++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		issues := linter.LintLine(line, fr.Lines, fmt.Sprintf("%s:%d", fr.File, fr.Lines))
+		fr.Issues = append(fr.Issues, issues...)
+	}
+	if err := scanner.Err(); err != nil {
+		fr.Error = err.Error()
+	}
+	return fr
+}
+
+func printReport(r *report) {
+	for _, fr := range r.Files {
+		for _, issue := range fr.Issues {
+			if len(issue.Key) > 0 {
+				fmt.Printf("%s:%d: [%s] %s: %s\n", fr.File, issue.Line, issue.Rule, issue.Key, issue.Message)
+			} else {
+				fmt.Printf("%s:%d: [%s] %s\n", fr.File, issue.Line, issue.Rule, issue.Message)
+			}
+		}
+		if len(fr.Error) > 0 {
+			fmt.Printf("%s: error: %s\n", fr.File, fr.Error)
+		}
+		fmt.Printf("%s: %d lines, %d issues\n", fr.File, fr.Lines, len(fr.Issues))
+	}
+	if len(r.Files) > 1 {
+		fmt.Printf("total: %d lines, %d issues\n", r.TotalLines, r.TotalIssues)
+	}
+}
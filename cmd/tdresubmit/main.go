@@ -0,0 +1,95 @@
+// Command tdresubmit upload the dead-letter files written by TDBatchConsumer again, e.g. after fixing the data in them.
+//
+//	tdresubmit -dir /var/log/ta/deadletter -url https://receiver.example.com
+//	tdresubmit -url https://receiver.example.com deadletter.2023-01-01.jsonl
+//
+// A file is removed when all its data has been uploaded. The data rejected again is written to the -rejected directory.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+)
+
+func main() {
+	var config thinkingdata.TDBatchConfig
+	var directory string
+	var verbose bool
+	flag.StringVar(&directory, "dir", "", "directory of the dead-letter files, used when no file is given")
+	flag.StringVar(&config.DeadLetterDir, "rejected", "", "directory of the data rejected again, \"rejected\" in the directory of the files by default")
+	flag.StringVar(&config.ServerUrl, "url", "", "server url of the receiver")
+	flag.StringVar(&config.AppId, "appid", "", "app id of the data without #app_id and app_id of the dead letter")
+	flag.IntVar(&config.BatchSize, "batch", thinkingdata.DefaultBatchSize, "count of events in a request")
+	flag.IntVar(&config.Timeout, "timeout", thinkingdata.DefaultTimeOut, "http timeout (mill second)")
+	flag.BoolVar(&config.Compress, "gzip", true, "compress the requests")
+	flag.BoolVar(&verbose, "v", false, "print the log of SDK")
+	flag.Parse()
+
+	files := flag.Args()
+	if len(config.ServerUrl) == 0 || (len(files) == 0 && len(directory) == 0) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if len(files) == 0 {
+		var err error
+		files, err = thinkingdata.ListDeadLetterFiles(directory)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+	if len(config.DeadLetterDir) == 0 {
+		config.DeadLetterDir = filepath.Join(filepath.Dir(files[0]), "rejected")
+	}
+	thinkingdata.SetLogLevel(thinkingdata.TDLogLevelError)
+	if verbose {
+		thinkingdata.SetLogLevel(thinkingdata.TDLogLevelInfo)
+	}
+
+	consumer, err := thinkingdata.NewBatchConsumerWithConfig(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	batchConsumer := consumer.(*thinkingdata.TDBatchConsumer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	failed := false
+	for _, name := range files {
+		count, err := batchConsumer.ResubmitDeadLetters(ctx, name)
+		fmt.Printf("%s: %d events\n", name, count)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+			failed = true
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	_ = consumer.Close()
+
+	if rejected, _ := thinkingdata.ListDeadLetterFiles(config.DeadLetterDir); len(rejected) > 0 {
+		fmt.Printf("data rejected again is in %s\n", config.DeadLetterDir)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
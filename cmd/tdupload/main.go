@@ -0,0 +1,72 @@
+// Command tdupload upload the files written by TDLogConsumer to the receiver, and keep tailing the directory.
+//
+//	tdupload -dir /var/log/ta -url https://receiver.example.com -appid APPID
+//
+// The offsets of the files are checkpointed, so a restarted tdupload continues where it stopped.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+)
+
+func main() {
+	var config thinkingdata.TDLogUploaderConfig
+	var once, verbose bool
+	flag.StringVar(&config.Directory, "dir", "", "directory of the files written by TDLogConsumer")
+	flag.StringVar(&config.FileNamePrefix, "prefix", "", "FileNamePrefix of TDLogConsumer")
+	flag.StringVar(&config.CheckpointFile, "checkpoint", "", "checkpoint file, "+thinkingdata.DefaultCheckpointName+" in the directory by default")
+	flag.StringVar(&config.ServerUrl, "url", "", "server url of the receiver")
+	flag.StringVar(&config.AppId, "appid", "", "app id of the data without #app_id")
+	flag.IntVar(&config.BatchSize, "batch", thinkingdata.DefaultBatchSize, "count of events in a request")
+	flag.IntVar(&config.Interval, "interval", thinkingdata.DefaultUploadInterval, "spacing of scanning the directory (second)")
+	flag.IntVar(&config.Timeout, "timeout", thinkingdata.DefaultTimeOut, "http timeout (mill second)")
+	flag.BoolVar(&config.Compress, "gzip", true, "compress the requests")
+	flag.BoolVar(&once, "once", false, "upload the current lines and exit")
+	flag.BoolVar(&verbose, "v", false, "print the log of SDK")
+	flag.Parse()
+
+	if len(config.Directory) == 0 || len(config.ServerUrl) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	thinkingdata.SetLogLevel(thinkingdata.TDLogLevelError)
+	if verbose {
+		thinkingdata.SetLogLevel(thinkingdata.TDLogLevelInfo)
+	}
+
+	uploader, err := thinkingdata.NewLogUploaderWithConfig(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if once {
+		stats, err := uploader.UploadOnce(ctx)
+		fmt.Printf("files: %d, events: %d, invalid lines: %d\n", stats.Files, stats.Events, stats.InvalidLines)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := uploader.Run(ctx); err != nil && err != context.Canceled {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
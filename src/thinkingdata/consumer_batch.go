@@ -3,6 +3,7 @@ package thinkingdata
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,7 +12,6 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 )
@@ -24,23 +24,77 @@ type TDBatchConsumer struct {
 	bufferMutex *sync.RWMutex
 	cacheMutex  *sync.RWMutex // cache mutex
 
-	buffer        []Data
-	batchSize     int      // flush event count each time
-	cacheBuffer   [][]Data // buffer
-	cacheCapacity int      // buffer max count
+	buffers       map[string][]Data // buffered data grouped by appId
+	batchSize     int               // flush event count each time
+	appBatchSize  map[string]int    // flush event count of specific appId
+	cacheBuffer   []*eventBatch     // buffer
+	cacheCapacity int               // buffer max count
 	HttpClient    *http.Client
+
+	async  bool         // upload data in sender goroutines
+	sender *batchSender // async upload pipeline, nil when async is disabled
+	spool  *batchSpool  // persist unsent batches, nil when SpoolDir is empty
+
+	retryPolicy *TDRetryPolicy
+	logger      *instanceLogger
+	metrics     TDMetrics
+
+	statsMutex *sync.Mutex
+	stats      map[string]*TDBatchAppStats
+
+	onSuccess     func(batch []Data)
+	onFailure     func(batch []Data, err error)
+	onDrop        func(batch []Data, reason string)
+	deadLetter    TDDeadLetterSink
+	bisectInvalid bool
+	onUnavailable func(err error) // called when the receiver can't be reached, set by TDFailoverConsumer
+	callbackMutex *sync.Mutex
+	callbacks     []func() // callbacks waiting for the locks to be released
+}
+
+// TDBatchAppStats statistics of an appId in TDBatchConsumer
+type TDBatchAppStats struct {
+	Buffered      int   // count of data in buffer
+	SentEvents    int64 // count of data accepted by receiver
+	SentBatches   int64 // count of batches accepted by receiver
+	FailedBatches int64 // count of batches which failed to upload, including the ones kept for retry
 }
 
 type TDBatchConfig struct {
-	ServerUrl     string       // serverUrl
-	AppId         string       // appId
-	BatchSize     int          // flush event count each time
-	Timeout       int          // http timeout (mill second)
-	Compress      bool         // enable compress data
-	AutoFlush     bool         // enable auto flush
-	Interval      int          // auto flush spacing (second)
-	CacheCapacity int          // cache event count
-	HttpClient    *http.Client // Custom http client. Set this parameter when you want to use your own http client
+	ServerUrl     string         // serverUrl
+	AppId         string         // appId
+	BatchSize     int            // flush event count each time
+	Timeout       int            // http timeout (mill second)
+	Compress      bool           // enable compress data
+	AutoFlush     bool           // enable auto flush
+	Interval      int            // auto flush spacing (second)
+	CacheCapacity int            // cache event count
+	HttpClient    *http.Client   // Custom http client. Set this parameter when you want to use your own http client
+	Async         bool           // enable async mode, Add only enqueues data and batches are uploaded by sender goroutines
+	QueueSize     int            // max count of batches waiting to be uploaded in async mode
+	SenderCount   int            // count of sender goroutines in async mode
+	SpoolDir      string         // directory to persist unsent batches, they are replayed when the consumer is created again
+	RetryPolicy   *TDRetryPolicy // retry policy of uploading, DefaultRetryPolicy is used when nil
+	AppBatchSize  map[string]int // flush event count of specific appId, BatchSize is used for the others
+	Logger        TDLogger       // logger of the consumer, the logger set by SetCustomLogger is used when nil
+	LogLevel      TDLogLevel     // log level of the consumer, the level set by SetLogLevel is used when 0
+	Metrics       TDMetrics      // receiver of the metrics of the consumer, they are discarded when nil
+
+	// The callbacks are called after the locks of the consumer are released, so they may add data to the consumer.
+	// In async mode, the callbacks of the uploads are called by a goroutine other than the senders.
+	// The batches must not be modified.
+	OnSuccess func(batch []Data)                // called when a batch is accepted by the receiver
+	OnFailure func(batch []Data, err error)     // called when a batch fails to upload, it's uploaded again later unless OnDrop is called as well
+	OnDrop    func(batch []Data, reason string) // called when a batch is discarded, reason is DropReasonRejected or DropReasonCacheCapacity
+
+	DeadLetter    TDDeadLetterSink // receive the data rejected by the receiver, e.g. NewDeadLetterConsumer(logConsumer)
+	DeadLetterDir string           // directory of the dead-letter files, used when DeadLetter is nil
+
+	// BisectInvalid split a batch rejected as invalid data format and upload the halves, until the invalid data
+	// is isolated, so that only the invalid data is dropped or written to the dead-letter sink.
+	BisectInvalid bool
+
+	onUnavailable func(err error) // see TDBatchConsumer.onUnavailable
 }
 
 const (
@@ -49,6 +103,10 @@ const (
 	MaxBatchSize         = 200
 	DefaultInterval      = 30
 	DefaultCacheCapacity = 50
+	DefaultQueueSize     = 100
+	DefaultSenderCount   = 4
+
+	maxErrorResponseSize = 4096 // bytes of the response kept when the status is unexpected
 )
 
 // NewBatchConsumer create TDBatchConsumer
@@ -93,10 +151,10 @@ func NewBatchConsumerWithConfig(config TDBatchConfig) (TDConsumer, error) {
 }
 
 func initBatchConsumer(config TDBatchConfig) (TDConsumer, error) {
+	logger := newInstanceLogger(config.Logger, config.LogLevel)
 	if config.ServerUrl == "" {
-		msg := fmt.Sprint("ServerUrl not be empty")
-		tdLogInfo(msg)
-		return nil, errors.New(msg)
+		logger.info(ErrEmptyServerUrl.Error())
+		return nil, ErrEmptyServerUrl
 	}
 	u, err := url.Parse(config.ServerUrl)
 	if err != nil {
@@ -104,13 +162,10 @@ func initBatchConsumer(config TDBatchConfig) (TDConsumer, error) {
 	}
 	u.Path = "/sync_server"
 
-	var batchSize int
-	if config.BatchSize > MaxBatchSize {
-		batchSize = MaxBatchSize
-	} else if config.BatchSize <= 0 {
-		batchSize = DefaultBatchSize
-	} else {
-		batchSize = config.BatchSize
+	batchSize := normalizeBatchSize(config.BatchSize)
+	appBatchSize := make(map[string]int, len(config.AppBatchSize))
+	for appId, size := range config.AppBatchSize {
+		appBatchSize[appId] = normalizeBatchSize(size)
 	}
 
 	var cacheCapacity int
@@ -127,6 +182,15 @@ func initBatchConsumer(config TDBatchConfig) (TDConsumer, error) {
 		timeout = time.Duration(config.Timeout) * time.Millisecond
 	}
 
+	deadLetter := config.DeadLetter
+	if deadLetter == nil && len(config.DeadLetterDir) > 0 {
+		deadLetter, err = NewDeadLetterDir(config.DeadLetterDir)
+		if err != nil {
+			logger.with(logAttr(LogKeyFile, config.DeadLetterDir), logAttr(LogKeyError, err)).error("init dead-letter directory failed: %s", err)
+			return nil, err
+		}
+	}
+
 	httpClient := config.HttpClient
 	if httpClient == nil {
 		httpClient = &http.Client{Timeout: timeout}
@@ -139,10 +203,48 @@ func initBatchConsumer(config TDBatchConfig) (TDConsumer, error) {
 		bufferMutex:   new(sync.RWMutex),
 		cacheMutex:    new(sync.RWMutex),
 		batchSize:     batchSize,
-		buffer:        make([]Data, 0, batchSize),
+		buffers:       make(map[string][]Data),
+		appBatchSize:  appBatchSize,
 		cacheCapacity: cacheCapacity,
-		cacheBuffer:   make([][]Data, 0, cacheCapacity),
+		cacheBuffer:   make([]*eventBatch, 0, cacheCapacity),
 		HttpClient:    httpClient,
+		async:         config.Async,
+		retryPolicy:   normalizeRetryPolicy(config.RetryPolicy),
+		logger:        logger,
+		metrics:       normalizeMetrics(config.Metrics),
+		statsMutex:    new(sync.Mutex),
+		stats:         make(map[string]*TDBatchAppStats),
+		onSuccess:     config.OnSuccess,
+		onFailure:     config.OnFailure,
+		onDrop:        config.OnDrop,
+		deadLetter:    deadLetter,
+		bisectInvalid: config.BisectInvalid,
+		onUnavailable: config.onUnavailable,
+		callbackMutex: new(sync.Mutex),
+	}
+
+	c.metrics.Gauge(MetricCacheCapacity, float64(cacheCapacity), metricLabel(MetricLabelConsumer, "batch"))
+	if len(config.SpoolDir) > 0 {
+		c.spool, err = newBatchSpool(config.SpoolDir, logger)
+		if err != nil {
+			c.logger.with(logAttr(LogKeyFile, config.SpoolDir), logAttr(LogKeyError, err)).error("init spool directory failed: %s", err)
+			return nil, err
+		}
+		c.refillCache()
+		c.reportCacheDepth()
+		c.logger.with(logAttr(LogKeyFile, config.SpoolDir)).info("replay %d batches from spool directory: %s", len(c.cacheBuffer), config.SpoolDir)
+	}
+
+	if c.async {
+		queueSize := config.QueueSize
+		if queueSize <= 0 {
+			queueSize = DefaultQueueSize
+		}
+		senderCount := config.SenderCount
+		if senderCount <= 0 {
+			senderCount = DefaultSenderCount
+		}
+		c.sender = newBatchSender(c, queueSize, senderCount)
 	}
 
 	var interval int
@@ -162,125 +264,577 @@ func initBatchConsumer(config TDBatchConfig) (TDConsumer, error) {
 		}()
 	}
 
-	tdLogInfo("Mode: batch consumer, appId: %s, serverUrl: %s", c.appId, c.serverUrl)
+	c.logger.with(logAttr(LogKeyAppId, c.appId)).info("Mode: batch consumer, appId: %s, serverUrl: %s", c.appId, c.serverUrl)
 
 	return c, nil
 }
 
+func normalizeBatchSize(size int) int {
+	if size > MaxBatchSize {
+		return MaxBatchSize
+	} else if size <= 0 {
+		return DefaultBatchSize
+	}
+	return size
+}
+
 func (c *TDBatchConsumer) Add(d Data) error {
+	return c.AddCtx(context.Background(), d)
+}
+
+// AddCtx add data to buffer, the context is used when the buffer needs to be uploaded.
+func (c *TDBatchConsumer) AddCtx(ctx context.Context, d Data) error {
+	appId := c.routeAppId(d)
 	c.bufferMutex.Lock()
-	c.buffer = append(c.buffer, d)
+	buffer := append(c.buffers[appId], d)
+	full := len(buffer) >= c.batchSizeOf(appId)
+	var batch *eventBatch
+	if c.async && full {
+		batch = &eventBatch{appId: appId, events: buffer}
+		delete(c.buffers, appId)
+	} else {
+		c.buffers[appId] = buffer
+	}
 	c.bufferMutex.Unlock()
+	if batch != nil {
+		c.spoolBatches(batch)
+	}
+
+	c.metrics.Counter(MetricEventsEnqueued, 1, c.metricLabels(appId)...)
+	if batch != nil {
+		c.metrics.Gauge(MetricBufferDepth, 0, c.metricLabels(appId)...)
+	} else {
+		c.metrics.Gauge(MetricBufferDepth, float64(len(buffer)), c.metricLabels(appId)...)
+	}
 
 	// log info
-	if GetLogLevel() <= TDLogLevelInfo {
-		jsonBytes, err := json.Marshal(d)
+	if c.logger.enabled(TDLogLevelInfo) {
+		jsonBytes, err := MarshalData(d)
 		if err != nil {
-			tdLogError(err.Error())
+			c.logger.with(logAttr(LogKeyAppId, appId), logAttr(LogKeyError, err)).error(err.Error())
 			return err
 		}
-		eventString := parseTime(jsonBytes)
-		tdLogInfo("Enqueue event data: %s", eventString)
+		c.logger.with(logAttr(LogKeyAppId, appId), logAttr(LogKeyEventName, d.EventName)).info("Enqueue event data: %s", jsonBytes)
+	}
+
+	if c.async {
+		if batch != nil {
+			return c.sender.enqueue(ctx, batch)
+		}
+		return nil
 	}
 
-	if c.getBufferLength() >= c.batchSize || c.getCacheLength() > 0 {
-		err := c.Flush()
+	if full || c.getCacheLength() > 0 {
+		c.logger.info("flush data")
+		err := c.innerFlush(ctx, false)
 		return err
 	}
 
 	return nil
 }
 
+// routeAppId the appId which the data is uploaded with, "#app_id" of data takes precedence over AppId of config.
+func (c *TDBatchConsumer) routeAppId(d Data) string {
+	if len(d.AppId) > 0 {
+		return d.AppId
+	}
+	return c.appId
+}
+
+func (c *TDBatchConsumer) batchSizeOf(appId string) int {
+	if size, ok := c.appBatchSize[appId]; ok {
+		return size
+	}
+	return c.batchSize
+}
+
+// Stats return the statistics of each appId
+func (c *TDBatchConsumer) Stats() map[string]TDBatchAppStats {
+	result := make(map[string]TDBatchAppStats)
+	c.statsMutex.Lock()
+	for appId, s := range c.stats {
+		result[appId] = *s
+	}
+	c.statsMutex.Unlock()
+
+	c.bufferMutex.RLock()
+	for appId, buffer := range c.buffers {
+		s := result[appId]
+		s.Buffered = len(buffer)
+		result[appId] = s
+	}
+	c.bufferMutex.RUnlock()
+	return result
+}
+
+// metricLabels the labels of the metrics of an appId
+func (c *TDBatchConsumer) metricLabels(appId string) []TDMetricLabel {
+	return []TDMetricLabel{metricLabel(MetricLabelConsumer, "batch"), metricLabel(MetricLabelAppId, appId)}
+}
+
+func (c *TDBatchConsumer) recordUpload(batch *eventBatch, done bool, err error) {
+	events := batch.events
+	if err == nil {
+		c.metrics.Counter(MetricEventsSent, int64(len(events)), c.metricLabels(batch.appId)...)
+		c.metrics.Counter(MetricBatchesSent, 1, c.metricLabels(batch.appId)...)
+		if c.onSuccess != nil {
+			c.notify(func() { c.onSuccess(events) })
+		}
+	} else {
+		c.metrics.Counter(MetricBatchesFailed, 1, c.metricLabels(batch.appId)...)
+		if c.onFailure != nil {
+			c.notify(func() { c.onFailure(events, err) })
+		}
+		if done {
+			c.writeDeadLetters(batch, err)
+			c.drop(events, DropReasonRejected)
+		}
+	}
+
+	c.statsMutex.Lock()
+	s, ok := c.stats[batch.appId]
+	if !ok {
+		s = &TDBatchAppStats{}
+		c.stats[batch.appId] = s
+	}
+	if err == nil {
+		s.SentEvents += int64(len(batch.events))
+		s.SentBatches++
+	} else {
+		s.FailedBatches++
+	}
+	c.statsMutex.Unlock()
+}
+
 func (c *TDBatchConsumer) timerFlush() error {
-	tdLogInfo("timer flush data")
-	return c.innerFlush()
+	c.logger.info("timer flush data")
+	if c.async {
+		return c.sender.flush(context.Background(), false)
+	}
+	return c.innerFlush(context.Background(), false)
 }
 
 func (c *TDBatchConsumer) Flush() error {
-	tdLogInfo("flush data")
-	return c.innerFlush()
+	return c.FlushCtx(context.Background())
 }
 
-func (c *TDBatchConsumer) innerFlush() error {
+// FlushCtx upload data, the http request is canceled when the context is done.
+func (c *TDBatchConsumer) FlushCtx(ctx context.Context) error {
+	c.logger.info("flush data")
+	if c.async {
+		return c.sender.flush(ctx, true)
+	}
+	return c.innerFlush(ctx, true)
+}
+
+// innerFlush move buffers to cacheBuffer and upload the cached batches.
+// If all is false, only the first cached batch is uploaded, otherwise it uploads all the batches
+// until one of them fails and is kept for retry. The batch is taken out of cacheBuffer while it's uploaded,
+// so that no lock is held during the requests and the backoff between them. The buffers are written to the spool
+// before they are cached, without the locks as well.
+func (c *TDBatchConsumer) innerFlush(ctx context.Context, all bool) error {
+	// deferred first to run after the locks are released
+	defer c.runCallbacks()
+
+	c.cacheMutex.Lock()
+	c.refillCache()
+	cached := len(c.cacheBuffer)
+	c.cacheMutex.Unlock()
+
+	batches := c.takeBuffers(all, cached)
+	c.spoolBatches(batches...)
 
+	c.cacheMutex.Lock()
+	c.cacheBuffer = append(c.cacheBuffer, batches...)
+	count := 1
+	if all {
+		count = len(c.cacheBuffer)
+	}
+	c.cacheMutex.Unlock()
+
+	defer func() {
+		c.cacheMutex.Lock()
+		for len(c.cacheBuffer) > c.cacheCapacity {
+			c.evictCache()
+		}
+		c.reportCacheDepth()
+		c.cacheMutex.Unlock()
+	}()
+
+	var err error
+	for i := 0; i < count; i++ {
+		batch := c.takeCachedBatch()
+		if batch == nil {
+			break
+		}
+		done, uploadErr := c.upload(ctx, batch)
+		if !done {
+			c.restoreCachedBatch(batch)
+		}
+		if uploadErr != nil {
+			err = uploadErr
+			if !done {
+				break
+			}
+		}
+	}
+
+	return err
+}
+
+// takeCachedBatch take the oldest batch out of cacheBuffer, it returns nil when cacheBuffer is empty.
+func (c *TDBatchConsumer) takeCachedBatch() *eventBatch {
 	c.cacheMutex.Lock()
 	defer c.cacheMutex.Unlock()
+	if len(c.cacheBuffer) == 0 {
+		return nil
+	}
+	batch := c.cacheBuffer[0]
+	c.cacheBuffer = c.cacheBuffer[1:]
+	c.reportCacheDepth()
+	return batch
+}
+
+// restoreCachedBatch put a batch taken by takeCachedBatch back to the head of cacheBuffer.
+func (c *TDBatchConsumer) restoreCachedBatch(batch *eventBatch) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	cache := make([]*eventBatch, 0, len(c.cacheBuffer)+1)
+	cache = append(cache, batch)
+	c.cacheBuffer = append(cache, c.cacheBuffer...)
+	c.reportCacheDepth()
+}
 
+// cacheBatch keep a batch which failed to upload, the oldest batch is dropped when cacheCapacity is exceeded.
+// The callbacks of the dropped batch are run by the caller with runCallbacks.
+func (c *TDBatchConsumer) cacheBatch(batch *eventBatch) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	c.cacheBuffer = append(c.cacheBuffer, batch)
+	if len(c.cacheBuffer) > c.cacheCapacity {
+		c.evictCache()
+	}
+	c.reportCacheDepth()
+}
+
+// evictCache drop the oldest batch of cacheBuffer, cacheMutex must be held by the caller.
+// A spooled batch is only dropped from memory, it stays on disk and is loaded again later.
+func (c *TDBatchConsumer) evictCache() {
+	evicted := c.cacheBuffer[0]
+	c.cacheBuffer = c.cacheBuffer[1:]
+	if len(evicted.segment) > 0 {
+		c.spool.evict(evicted.segment)
+	} else {
+		c.drop(evicted.events, DropReasonCacheCapacity)
+	}
+}
+
+// drop report the events which are discarded
+func (c *TDBatchConsumer) drop(events []Data, reason string) {
+	c.metrics.Counter(MetricEventsDropped, int64(len(events)), metricLabel(MetricLabelReason, reason))
+	if c.onDrop != nil {
+		c.notify(func() { c.onDrop(events, reason) })
+	}
+}
+
+// notify queue a callback, it's called by runCallbacks after the locks are released.
+func (c *TDBatchConsumer) notify(callback func()) {
+	c.callbackMutex.Lock()
+	c.callbacks = append(c.callbacks, callback)
+	c.callbackMutex.Unlock()
+}
+
+// runCallbacks call the queued callbacks, the locks of the consumer must not be held by the caller.
+func (c *TDBatchConsumer) runCallbacks() {
+	c.callbackMutex.Lock()
+	callbacks := c.callbacks
+	c.callbacks = nil
+	c.callbackMutex.Unlock()
+
+	for _, callback := range callbacks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					c.logger.error("callback panic: %+v", r)
+				}
+			}()
+			callback()
+		}()
+	}
+}
+
+// reportCacheDepth cacheMutex must be held by the caller.
+func (c *TDBatchConsumer) reportCacheDepth() {
+	c.metrics.Gauge(MetricCacheDepth, float64(len(c.cacheBuffer)), metricLabel(MetricLabelConsumer, "batch"))
+}
+
+// refillCache load spooled batches while cacheBuffer has free space, cacheMutex must be held by the caller.
+func (c *TDBatchConsumer) refillCache() {
+	if c.spool == nil {
+		return
+	}
+	for len(c.cacheBuffer) < c.cacheCapacity {
+		batch, ok := c.spool.next()
+		if !ok {
+			return
+		}
+		if len(batch.appId) == 0 {
+			batch.appId = c.appId
+		}
+		c.cacheBuffer = append(c.cacheBuffer, batch)
+	}
+}
+
+// takeBuffers take the buffers out as new batches. The full buffers are always taken, the others only when all is true
+// or there is nothing else to upload, cached is the count of the cached batches.
+func (c *TDBatchConsumer) takeBuffers(all bool, cached int) []*eventBatch {
 	c.bufferMutex.Lock()
 	defer c.bufferMutex.Unlock()
 
-	if len(c.buffer) == 0 && len(c.cacheBuffer) == 0 {
-		return nil
+	var batches []*eventBatch
+	for appId, buffer := range c.buffers {
+		if len(buffer) >= c.batchSizeOf(appId) {
+			batches = append(batches, &eventBatch{appId: appId, events: buffer})
+			delete(c.buffers, appId)
+			c.metrics.Gauge(MetricBufferDepth, 0, c.metricLabels(appId)...)
+		}
 	}
+	if all || cached+len(batches) == 0 {
+		for appId, buffer := range c.buffers {
+			batches = append(batches, &eventBatch{appId: appId, events: buffer})
+			delete(c.buffers, appId)
+			c.metrics.Gauge(MetricBufferDepth, 0, c.metricLabels(appId)...)
+		}
+	}
+	return batches
+}
+
+// spoolBatches write the new batches to the spool if enabled. The locks of the consumer must not be held by the caller,
+// so that Add isn't blocked by the disk.
+func (c *TDBatchConsumer) spoolBatches(batches ...*eventBatch) {
+	if c.spool == nil {
+		return
+	}
+	for _, batch := range batches {
+		segment, err := c.spool.write(batch.events)
+		if err != nil {
+			c.logger.with(logAttr(LogKeyAppId, batch.appId), logAttr(LogKeyError, err)).error("write spool segment failed: %s", err)
+			continue
+		}
+		batch.segment = segment
+	}
+}
 
+// upload send a batch to receiver with retries. done is true when the batch has reached a final state,
+// accepted or rejected by the receiver, in which case the batch must not be uploaded again.
+func (c *TDBatchConsumer) upload(ctx context.Context, batch *eventBatch) (done bool, err error) {
+	bisected := false
 	defer func() {
-		if len(c.cacheBuffer) > c.cacheCapacity {
-			c.cacheBuffer = c.cacheBuffer[1:]
+		if done && len(batch.segment) > 0 {
+			// the rejected data of a bisected batch is reported by the halves
+			if err == nil || bisected {
+				c.spool.remove(batch.segment)
+			} else {
+				c.spool.reject(batch.segment)
+			}
 		}
 	}()
 
-	if len(c.cacheBuffer) == 0 || len(c.buffer) >= c.batchSize {
-		c.cacheBuffer = append(c.cacheBuffer, c.buffer)
-		c.buffer = make([]Data, 0, c.batchSize)
+	done, err = c.sendBatch(ctx, batch)
+	if c.shouldBisect(batch, done, err) {
+		bisected = true
+		events := batch.events
+		return c.bisect(ctx, batch, func(n int) {
+			// keep only the data which hasn't reached a final state on disk, so that it's not replayed twice
+			if len(batch.segment) > 0 && n < len(events) {
+				c.spool.rewrite(batch.segment, events[n:])
+			}
+		})
+	}
+	c.recordUpload(batch, done, err)
+	if !done && c.onUnavailable != nil && isUnavailable(ctx, err) {
+		// uploads of the timer and the senders report it as well, no caller sees their errors
+		c.notify(func() { c.onUnavailable(err) })
 	}
+	return done, err
+}
 
-	err := c.uploadEvents()
+func (c *TDBatchConsumer) shouldBisect(batch *eventBatch, done bool, err error) bool {
+	return done && err != nil && c.bisectInvalid && len(batch.events) > 1 && errors.Is(err, ErrInvalidDataFormat)
+}
 
-	return err
+// bisect upload the halves of a batch rejected as invalid data format, they are split again until the invalid data
+// is isolated. settled is called with the count of the leading data of the batch which has reached a final state,
+// after each half. If a half can't be uploaded, the batch keeps the data which hasn't reached a final state,
+// which is always a suffix of the batch.
+func (c *TDBatchConsumer) bisect(ctx context.Context, batch *eventBatch, settled func(n int)) (bool, error) {
+	c.metrics.Counter(MetricBatchesFailed, 1, c.metricLabels(batch.appId)...)
+	mid := len(batch.events) / 2
+	halves := [][]Data{batch.events[:mid], batch.events[mid:]}
+	c.logger.with(logAttr(LogKeyAppId, batch.appId), logAttr(LogKeyBatchSize, len(batch.events))).info("batch is rejected as invalid data format, upload it in halves of %d and %d", len(halves[0]), len(halves[1]))
+
+	var rejected error
+	offset := 0
+	for i, half := range halves {
+		halfBatch := &eventBatch{appId: batch.appId, events: half}
+		done, err := c.sendBatch(ctx, halfBatch)
+		if c.shouldBisect(halfBatch, done, err) {
+			base := offset
+			done, err = c.bisect(ctx, halfBatch, func(n int) { settled(base + n) })
+		} else {
+			c.recordUpload(halfBatch, done, err)
+		}
+		if !done {
+			rest := make([]Data, 0, len(batch.events)-offset)
+			rest = append(rest, halfBatch.events...)
+			for _, h := range halves[i+1:] {
+				rest = append(rest, h...)
+			}
+			batch.events = rest
+			return false, err
+		}
+		if err != nil {
+			rejected = err
+		}
+		offset += len(half)
+		settled(offset)
+	}
+	return true, rejected
+}
+
+// sendBatch send a batch to receiver with retries, see upload.
+func (c *TDBatchConsumer) sendBatch(ctx context.Context, batch *eventBatch) (done bool, err error) {
+	buffer := batch.events
+	jsonBytes, err := marshalDataList(buffer)
+	if err != nil {
+		return false, err
+	}
+	params := string(jsonBytes)
+	logger := c.logger.with(logAttr(LogKeyAppId, batch.appId), logAttr(LogKeyBatchSize, len(buffer)))
+
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+
+		var statusCode, code int
+		var body string
+		var retryable, rejected bool
+		start := time.Now()
+		statusCode, code, body, err = c.send(ctx, batch.appId, params, len(buffer))
+		c.metrics.Histogram(MetricSendDuration, time.Since(start).Seconds(), c.metricLabels(batch.appId)...)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, err
+			}
+			// network error or a response which can't be parsed, the receiver may be back later
+			retryable = true
+		} else if statusCode == http.StatusOK {
+			if code == 0 {
+				logger.with(logAttr(LogKeyStatusCode, statusCode)).info("send success： %v", params)
+				return true, nil
+			}
+			retryable = c.retryPolicy.isRetryableCode(code)
+			rejected = !retryable
+			err = &ReceiverError{StatusCode: statusCode, Code: code, BatchSize: len(buffer), AppId: batch.appId, Body: body, Retryable: retryable, Err: receiverCodeError(code)}
+		} else {
+			// the status doesn't tell whether the data is invalid, e.g. 401 of a proxy, so the batch is always kept
+			retryable = c.retryPolicy.isRetryableStatus(statusCode)
+			err = &ReceiverError{StatusCode: statusCode, Code: code, BatchSize: len(buffer), AppId: batch.appId, Body: body, Retryable: true, Err: ErrUnexpectedStatus}
+		}
+
+		attemptLogger := logger.with(logAttr(LogKeyStatusCode, statusCode), logAttr(LogKeyCode, code), logAttr(LogKeyAttempt, attempt), logAttr(LogKeyError, err))
+		if rejected {
+			attemptLogger.error(err.Error())
+			return true, err
+		}
+		if !retryable {
+			// keep the batch in cache, it will be uploaded again by the next flush
+			attemptLogger.error(err.Error())
+			return false, err
+		}
+		if attempt >= c.retryPolicy.MaxAttempts {
+			// keep the batch in cache, it will be uploaded again by the next flush
+			attemptLogger.error("%s, give up after %d attempts", err.Error(), attempt)
+			return false, err
+		}
+
+		delay := c.retryPolicy.delay(attempt)
+		c.metrics.Counter(MetricRetries, 1, c.metricLabels(batch.appId)...)
+		attemptLogger.warning("%s, retry in %v", err.Error(), delay)
+		if err := sleepCtx(ctx, delay); err != nil {
+			return false, err
+		}
+	}
 }
 
-func (c *TDBatchConsumer) uploadEvents() error {
-	buffer := c.cacheBuffer[0]
+// uploadEvents upload the events in batches of consecutive data of the same appId, without caching them.
+// uploaded is called with the count of the leading events which have reached a final state after each batch.
+func (c *TDBatchConsumer) uploadEvents(ctx context.Context, events []Data, uploaded func(n int)) error {
+	for start := 0; start < len(events); {
+		appId := c.routeAppId(events[start])
+		end := start + 1
+		for end < len(events) && end-start < c.batchSizeOf(appId) && c.routeAppId(events[end]) == appId {
+			end++
+		}
 
-	jsonBytes, err := json.Marshal(buffer)
-	if err == nil {
-		params := parseTime(jsonBytes)
-		for i := 0; i < 3; i++ {
-			statusCode, code, err := c.send(params, len(buffer))
-			if statusCode == 200 {
-				c.cacheBuffer = c.cacheBuffer[1:]
-				switch code {
-				case 0:
-					tdLogInfo("send success： %v", params)
-					return nil
-				case 1, -1:
-					msg := "invalid data format"
-					tdLogError(msg)
-					return fmt.Errorf(msg)
-				case -2:
-					msg := "APP ID doesn't exist"
-					tdLogError(msg)
-					return fmt.Errorf(msg)
-				case -3:
-					msg := "invalid ip transmission"
-					tdLogError(msg)
-					return fmt.Errorf(msg)
-				default:
-					msg := "unknown error"
-					tdLogError(msg)
-					return fmt.Errorf(msg)
-				}
-			} else {
-				if err != nil {
-					tdLogError(err.Error())
-					return err
-				} else {
-					if i == 2 {
-						msg := fmt.Sprintf("network error, but err is nil. Status code is: %v", statusCode)
-						tdLogError(msg)
-						return fmt.Errorf(msg)
-					}
-				}
+		batch := &eventBatch{appId: appId, events: events[start:end]}
+		done, err := c.upload(ctx, batch)
+		c.runCallbacks()
+		if err != nil && !done {
+			if sent := end - start - len(batch.events); sent > 0 {
+				// the leading halves of a bisected batch are uploaded
+				uploaded(start + sent)
 			}
+			return err
 		}
+		start = end
+		uploaded(start)
 	}
-	return err
+	return nil
+}
+
+// drain take all the buffered and cached data out of the consumer, spooled batches included.
+func (c *TDBatchConsumer) drain() []Data {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+
+	events := make([]Data, 0)
+	for {
+		c.refillCache()
+		if len(c.cacheBuffer) == 0 {
+			break
+		}
+		for _, batch := range c.cacheBuffer {
+			events = append(events, batch.events...)
+			if len(batch.segment) > 0 {
+				c.spool.remove(batch.segment)
+			}
+		}
+		c.cacheBuffer = make([]*eventBatch, 0, c.cacheCapacity)
+	}
+	c.reportCacheDepth()
+	for appId, buffer := range c.buffers {
+		events = append(events, buffer...)
+		delete(c.buffers, appId)
+		c.metrics.Gauge(MetricBufferDepth, 0, c.metricLabels(appId)...)
+	}
+	return events
 }
 
 func (c *TDBatchConsumer) FlushAll() error {
+	return c.flushAll(context.Background())
+}
+
+func (c *TDBatchConsumer) flushAll(ctx context.Context) error {
 	for c.getCacheLength() > 0 || c.getBufferLength() > 0 {
-		if err := c.Flush(); err != nil {
-			if !strings.Contains(err.Error(), "ThinkingDataError") {
+		if err := c.FlushCtx(ctx); err != nil {
+			// the batch rejected by receiver has been dropped, go on with the others
+			var receiverErr *ReceiverError
+			if !errors.As(err, &receiverErr) || receiverErr.Retryable {
 				return err
 			}
 		}
@@ -289,15 +843,26 @@ func (c *TDBatchConsumer) FlushAll() error {
 }
 
 func (c *TDBatchConsumer) Close() error {
-	tdLogInfo("batch consumer close")
-	return c.FlushAll()
+	return c.CloseCtx(context.Background())
+}
+
+// CloseCtx upload all the remaining data until the context is done.
+func (c *TDBatchConsumer) CloseCtx(ctx context.Context) error {
+	c.logger.info("batch consumer close")
+	err := c.flushAll(ctx)
+	if c.async {
+		if closeErr := c.sender.close(ctx); err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
 func (c *TDBatchConsumer) IsStringent() bool {
 	return false
 }
 
-func (c *TDBatchConsumer) send(data string, size int) (statusCode int, code int, err error) {
+func (c *TDBatchConsumer) send(ctx context.Context, appId string, data string, size int) (statusCode int, code int, body string, err error) {
 	var encodedData string
 	var compressType = "gzip"
 	if c.compress {
@@ -307,13 +872,16 @@ func (c *TDBatchConsumer) send(data string, size int) (statusCode int, code int,
 		compressType = "none"
 	}
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, "", err
 	}
 	postData := bytes.NewBufferString(encodedData)
 
 	var resp *http.Response
-	req, _ := http.NewRequest("POST", c.serverUrl, postData)
-	req.Header["appid"] = []string{c.appId}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverUrl, postData)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	req.Header["appid"] = []string{appId}
 	req.Header.Set("user-agent", "ta-go-sdk")
 	req.Header.Set("version", SdkVersion)
 	req.Header.Set("compress", compressType)
@@ -323,30 +891,33 @@ func (c *TDBatchConsumer) send(data string, size int) (statusCode int, code int,
 	resp, err = c.HttpClient.Do(req)
 
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, "", err
 	}
+	c.metrics.Counter(MetricBytesSent, int64(len(encodedData)), c.metricLabels(appId)...)
 
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			tdLogError("close response body error: %v", err)
+			c.logger.error("close response body error: %v", err)
 		}
 	}(resp.Body)
 
 	if resp.StatusCode == http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
+		content, _ := ioutil.ReadAll(resp.Body)
 		var result struct {
 			Code int
 		}
 
-		err = json.Unmarshal(body, &result)
+		err = json.Unmarshal(content, &result)
 		if err != nil {
-			return resp.StatusCode, 1, err
+			return resp.StatusCode, 0, string(content), fmt.Errorf("parse response failed: %w, return content: %s", err, content)
 		}
 
-		return resp.StatusCode, result.Code, nil
+		return resp.StatusCode, result.Code, string(content), nil
 	} else {
-		return resp.StatusCode, -1, nil
+		// the response of an unexpected status may be a large error page
+		content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorResponseSize))
+		return resp.StatusCode, -1, string(content), nil
 	}
 }
 
@@ -368,7 +939,11 @@ func encodeData(data string) (string, error) {
 func (c *TDBatchConsumer) getBufferLength() int {
 	c.bufferMutex.RLock()
 	defer c.bufferMutex.RUnlock()
-	return len(c.buffer)
+	length := 0
+	for _, buffer := range c.buffers {
+		length += len(buffer)
+	}
+	return length
 }
 
 func (c *TDBatchConsumer) getCacheLength() int {
@@ -0,0 +1,253 @@
+package thinkingdata
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// batchSender is the async upload pipeline of TDBatchConsumer.
+// Add only puts full batches into a bounded queue, and a pool of goroutines uploads them.
+type batchSender struct {
+	consumer *TDBatchConsumer
+	queue    chan *eventBatch
+	wg       sync.WaitGroup // running sender goroutines
+
+	// closeMutex only guards closed, it's not held while enqueue blocks or runs the callbacks,
+	// which may add data to the consumer from the sender goroutines.
+	closeMutex *sync.RWMutex
+	closed     bool
+	enqueuing  sync.WaitGroup // enqueue calls which passed the check of closed, the queue is closed after them
+
+	pendingMutex *sync.Mutex
+	pendingCond  *sync.Cond
+	pending      int   // batches enqueued but not uploaded yet
+	err          error // last upload error, reported by the flush which waits for the upload
+
+	ctxMutex *sync.Mutex
+	ctx      context.Context // context of the uploads, canceled when a flush or close stops waiting for them
+	cancel   context.CancelFunc
+
+	// The callbacks of the uploads run in another goroutine, so that a callback which adds data
+	// and blocks on the full queue doesn't stop the senders.
+	callbackSignal chan struct{}
+	callbackDone   chan struct{}
+}
+
+func newBatchSender(c *TDBatchConsumer, queueSize, senderCount int) *batchSender {
+	s := &batchSender{
+		consumer:     c,
+		queue:        make(chan *eventBatch, queueSize),
+		closeMutex:   new(sync.RWMutex),
+		pendingMutex: new(sync.Mutex),
+		ctxMutex:     new(sync.Mutex),
+
+		callbackSignal: make(chan struct{}, 1),
+		callbackDone:   make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.pendingCond = sync.NewCond(s.pendingMutex)
+	c.metrics.Gauge(MetricQueueCapacity, float64(queueSize), metricLabel(MetricLabelConsumer, "batch"))
+	for i := 0; i < senderCount; i++ {
+		s.wg.Add(1)
+		go s.run()
+	}
+	go s.runCallbacks()
+	return s
+}
+
+func (s *batchSender) run() {
+	defer s.wg.Done()
+	for batch := range s.queue {
+		s.reportQueueDepth()
+		done, err := s.consumer.upload(s.uploadContext(), batch)
+		if !done {
+			// keep the batch, it will be enqueued again by the next flush
+			s.consumer.cacheBatch(batch)
+		}
+		select {
+		case s.callbackSignal <- struct{}{}:
+		default:
+			// the callback goroutine is signaled already
+		}
+		s.done(err)
+	}
+}
+
+func (s *batchSender) runCallbacks() {
+	defer close(s.callbackDone)
+	for range s.callbackSignal {
+		s.consumer.runCallbacks()
+	}
+}
+
+// enqueue put a batch into the queue, it blocks while the queue is full.
+// When the context is done first, the batch is cached and uploaded by the next flush.
+func (s *batchSender) enqueue(ctx context.Context, batch *eventBatch) error {
+	if batch == nil || len(batch.events) == 0 {
+		return nil
+	}
+	s.closeMutex.RLock()
+	closed := s.closed
+	if !closed {
+		s.enqueuing.Add(1)
+	}
+	s.closeMutex.RUnlock()
+	if closed {
+		s.consumer.cacheBatch(batch)
+		s.consumer.runCallbacks()
+		err := fmt.Errorf("add event failed: %w", ErrConsumerClosed)
+		s.consumer.logger.with(logAttr(LogKeyAppId, batch.appId), logAttr(LogKeyBatchSize, len(batch.events))).error(err.Error())
+		return err
+	}
+	defer s.enqueuing.Done()
+
+	s.pendingMutex.Lock()
+	s.pending++
+	s.pendingMutex.Unlock()
+
+	select {
+	case s.queue <- batch:
+		s.reportQueueDepth()
+		return nil
+	case <-ctx.Done():
+		s.consumer.cacheBatch(batch)
+		s.done(nil)
+		s.consumer.runCallbacks()
+		return ctx.Err()
+	}
+}
+
+func (s *batchSender) uploadContext() context.Context {
+	s.ctxMutex.Lock()
+	defer s.ctxMutex.Unlock()
+	return s.ctx
+}
+
+// cancelUploads cancel the in-flight requests, the batches are cached and uploaded again by the next flush.
+// If renew is true, the batches uploaded later get a new context, otherwise they are canceled as well.
+func (s *batchSender) cancelUploads(renew bool) {
+	s.ctxMutex.Lock()
+	defer s.ctxMutex.Unlock()
+	s.cancel()
+	if renew {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+}
+
+func (s *batchSender) reportQueueDepth() {
+	s.consumer.metrics.Gauge(MetricQueueDepth, float64(len(s.queue)), metricLabel(MetricLabelConsumer, "batch"))
+}
+
+func (s *batchSender) done(err error) {
+	s.pendingMutex.Lock()
+	s.pending--
+	if err != nil {
+		s.err = err
+	}
+	if s.pending == 0 {
+		s.pendingCond.Broadcast()
+	}
+	s.pendingMutex.Unlock()
+}
+
+// flush enqueue the cached batches and the current buffer.
+// If wait is true, it returns after all the in-flight batches have been uploaded, and reports the last error of them.
+// When the context is done first, the in-flight requests are canceled.
+func (s *batchSender) flush(ctx context.Context, wait bool) error {
+	c := s.consumer
+	if wait {
+		// the errors of the uploads which are not waited for have been logged, don't report them here
+		s.pendingMutex.Lock()
+		s.err = nil
+		s.pendingMutex.Unlock()
+	}
+
+	c.cacheMutex.Lock()
+	c.refillCache()
+	batches := c.cacheBuffer
+	c.cacheBuffer = make([]*eventBatch, 0, c.cacheCapacity)
+	c.reportCacheDepth()
+	c.cacheMutex.Unlock()
+
+	buffers := c.takeBuffers(true, 0)
+	c.spoolBatches(buffers...)
+	batches = append(batches, buffers...)
+
+	for i, batch := range batches {
+		if err := s.enqueue(ctx, batch); err != nil {
+			for _, b := range batches[i+1:] {
+				c.cacheBatch(b)
+			}
+			c.runCallbacks()
+			return err
+		}
+	}
+
+	if !wait {
+		return nil
+	}
+	if err := s.wait(ctx); err != nil {
+		s.cancelUploads(true)
+		return err
+	}
+	// the callbacks of the batches run before Flush returns
+	c.runCallbacks()
+
+	s.pendingMutex.Lock()
+	err := s.err
+	s.err = nil
+	s.pendingMutex.Unlock()
+	return err
+}
+
+// wait block until there is no in-flight batch or the context is done.
+func (s *batchSender) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.pendingMutex.Lock()
+		for s.pending > 0 {
+			s.pendingCond.Wait()
+		}
+		s.pendingMutex.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stop accepting batches and wait for the sender goroutines to exit.
+// When the context is done first, the in-flight requests are canceled, the batches stay in the spool if enabled.
+func (s *batchSender) close(ctx context.Context) error {
+	s.closeMutex.Lock()
+	if s.closed {
+		s.closeMutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.closeMutex.Unlock()
+	defer s.cancelUploads(false)
+
+	done := make(chan struct{})
+	go func() {
+		// the blocked enqueue calls finish while the senders keep draining the queue
+		s.enqueuing.Wait()
+		close(s.queue)
+		s.wg.Wait()
+		close(s.callbackSignal)
+		<-s.callbackDone
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,158 @@
+package thinkingdata_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata/thinkingdatatest"
+)
+
+func newAsyncConsumer(t *testing.T, r *thinkingdatatest.Receiver, config thinkingdata.TDBatchConfig) *thinkingdata.TDBatchConsumer {
+	t.Helper()
+	config.ServerUrl = r.URL
+	config.AppId = "app"
+	config.Compress = true
+	config.Async = true
+	if config.RetryPolicy == nil {
+		config.RetryPolicy = &thinkingdata.TDRetryPolicy{MaxAttempts: 1}
+	}
+	c, err := thinkingdata.NewBatchConsumerWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*thinkingdata.TDBatchConsumer)
+}
+
+func trackData(eventName string) thinkingdata.Data {
+	return thinkingdata.Data{AccountId: "account", Type: thinkingdata.Track, EventName: eventName}
+}
+
+// waitFor poll the condition until it's true or the timeout is reached.
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAsyncFlushCtxCancelsUpload(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.SetLatency(10 * time.Second)
+	c := newAsyncConsumer(t, r, thinkingdata.TDBatchConfig{BatchSize: 10})
+
+	c.Add(trackData("a"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.FlushCtx(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	// the in-flight request is canceled as well, so the batch is cached again soon
+	waitFor(t, 2*time.Second, func() bool { return c.Stats()["app"].FailedBatches == 1 })
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("the upload is not canceled, it took %s", elapsed)
+	}
+
+	r.SetLatency(0)
+	r.Reset()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if events := r.Events(); len(events) != 1 || events[0].EventName != "a" {
+		t.Errorf("got events %v", events)
+	}
+}
+
+func TestAsyncCloseCtxCancelsUpload(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.SetLatency(10 * time.Second)
+	c := newAsyncConsumer(t, r, thinkingdata.TDBatchConfig{BatchSize: 1})
+
+	c.Add(trackData("a"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.CloseCtx(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return c.Stats()["app"].FailedBatches == 1 })
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("the upload is not canceled, it took %s", elapsed)
+	}
+}
+
+func TestAsyncFlushReportsOwnErrors(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.FailNext(1, http.StatusServiceUnavailable)
+	c := newAsyncConsumer(t, r, thinkingdata.TDBatchConfig{BatchSize: 1})
+	defer c.Close()
+
+	// the batch uploaded in background fails and is cached
+	c.Add(trackData("a"))
+	waitFor(t, 2*time.Second, func() bool { return c.Stats()["app"].FailedBatches == 1 })
+	// let the sender finish the batch
+	time.Sleep(50 * time.Millisecond)
+
+	// the next flush uploads it again, the earlier error is not reported
+	if err := c.Flush(); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if events := r.Events(); len(events) != 1 {
+		t.Errorf("got %d events, want 1", len(events))
+	}
+}
+
+func TestAsyncCallbackAddsData(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.SetLatency(time.Millisecond)
+
+	var c *thinkingdata.TDBatchConsumer
+	followup := func(batch []thinkingdata.Data) {
+		for _, d := range batch {
+			if d.EventName == "a" {
+				c.Add(trackData("followup"))
+			}
+		}
+	}
+	c = newAsyncConsumer(t, r, thinkingdata.TDBatchConfig{
+		BatchSize:     1,
+		QueueSize:     1,
+		SenderCount:   1,
+		CacheCapacity: 1,
+		OnSuccess:     followup,
+		OnDrop:        func(batch []thinkingdata.Data, reason string) { followup(batch) },
+	})
+
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		for i := 0; i < 100; i++ {
+			c.Add(trackData("a"))
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// the callbacks add data from the sender goroutine while Close is waiting, it must not deadlock
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.CloseCtx(ctx); errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("deadlock on closing")
+	}
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock on adding")
+	}
+}
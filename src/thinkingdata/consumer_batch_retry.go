@@ -0,0 +1,123 @@
+package thinkingdata
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 3
+	DefaultBaseDelay   = 200 * time.Millisecond
+	DefaultMaxDelay    = 10 * time.Second
+	DefaultJitter      = 0.2
+)
+
+// TDRetryPolicy controls how TDBatchConsumer retries a batch which failed to upload.
+// Network errors are always retried, http status and receiver codes are retried only when listed.
+// A batch which still fails with an http status other than 200 is kept in cache and uploaded again by the next flush,
+// only the batch rejected by a code of the receiver is dropped.
+type TDRetryPolicy struct {
+	MaxAttempts     int           // max count of requests for one batch, including the first one
+	BaseDelay       time.Duration // delay before the first retry, it's doubled for every further retry
+	MaxDelay        time.Duration // upper limit of the delay
+	Jitter          float64       // [0, 1], the delay is reduced randomly by up to this fraction
+	RetryableStatus []int         // http status codes which are worth retrying, e.g. 503, the default list is used when nil
+	RetryableCodes  []int         // receiver codes which are worth retrying, none by default
+}
+
+// DefaultRetryPolicy the policy used when TDBatchConfig.RetryPolicy is nil
+func DefaultRetryPolicy() *TDRetryPolicy {
+	return &TDRetryPolicy{
+		MaxAttempts:     DefaultMaxAttempts,
+		BaseDelay:       DefaultBaseDelay,
+		MaxDelay:        DefaultMaxDelay,
+		Jitter:          DefaultJitter,
+		RetryableStatus: defaultRetryableStatus(),
+	}
+}
+
+func defaultRetryableStatus() []int {
+	return []int{
+		http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+}
+
+func normalizeRetryPolicy(p *TDRetryPolicy) *TDRetryPolicy {
+	if p == nil {
+		return DefaultRetryPolicy()
+	}
+	policy := *p
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultMaxAttempts
+	}
+	if policy.BaseDelay < 0 {
+		policy.BaseDelay = 0
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = DefaultMaxDelay
+	}
+	if policy.Jitter < 0 {
+		policy.Jitter = 0
+	} else if policy.Jitter > 1 {
+		policy.Jitter = 1
+	}
+	// nil lists take the defaults, set an empty list to disable the retries
+	if policy.RetryableStatus == nil {
+		policy.RetryableStatus = defaultRetryableStatus()
+	}
+	return &policy
+}
+
+func (p *TDRetryPolicy) isRetryableStatus(statusCode int) bool {
+	return containsInt(p.RetryableStatus, statusCode)
+}
+
+func (p *TDRetryPolicy) isRetryableCode(code int) bool {
+	return containsInt(p.RetryableCodes, code)
+}
+
+// delay the waiting time before the given retry, retry starts from 1.
+func (p *TDRetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(float64(d) * p.Jitter * rand.Float64())
+	}
+	return d
+}
+
+// sleepCtx wait for the duration, it returns early with the error of the context when the context is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func containsInt(list []int, v int) bool {
+	for _, i := range list {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
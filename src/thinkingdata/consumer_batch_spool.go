@@ -0,0 +1,180 @@
+package thinkingdata
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spoolSegmentSuffix  = ".seg"
+	spoolRejectedSuffix = ".rejected"
+	spoolTempSuffix     = ".tmp"
+)
+
+// eventBatch is a group of data uploaded in one request.
+type eventBatch struct {
+	appId   string // appId the batch is uploaded with
+	events  []Data
+	segment string // spool segment file of the batch, empty when the spool is disabled
+}
+
+// batchSpool persists unsent batches of TDBatchConsumer as segment files, one event per line.
+// A segment is deleted after the receiver accepts the batch (code 0), and renamed with the
+// suffix ".rejected" when the receiver rejects it, so that it is not replayed again.
+type batchSpool struct {
+	directory string
+	mutex     *sync.Mutex
+	seq       uint64
+	backlog   []string // segments on disk which are not in the memory cache, oldest first
+	logger    *instanceLogger
+}
+
+func newBatchSpool(directory string, logger *instanceLogger) (*batchSpool, error) {
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return nil, err
+	}
+	s := &batchSpool{
+		directory: directory,
+		mutex:     new(sync.Mutex),
+		logger:    logger,
+	}
+
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), spoolSegmentSuffix) {
+			continue
+		}
+		s.backlog = append(s.backlog, f.Name())
+	}
+	sort.Strings(s.backlog)
+	return s, nil
+}
+
+// write persist the batch as a new segment, and return the name of the segment.
+func (s *batchSpool) write(events []Data) (string, error) {
+	s.mutex.Lock()
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq, spoolSegmentSuffix)
+	s.mutex.Unlock()
+	return name, s.writeSegment(name, events)
+}
+
+// rewrite replace the events of a segment, e.g. with the ones which are not uploaded yet.
+func (s *batchSpool) rewrite(name string, events []Data) {
+	if err := s.writeSegment(name, events); err != nil {
+		s.logger.with(logAttr(LogKeyFile, name), logAttr(LogKeyError, err)).error("rewrite spool segment failed: %s", err)
+	}
+}
+
+func (s *batchSpool) writeSegment(name string, events []Data) error {
+	var buf bytes.Buffer
+	for _, d := range events {
+		jsonBytes, err := MarshalData(d)
+		if err != nil {
+			return err
+		}
+		buf.Write(jsonBytes)
+		buf.WriteByte('\n')
+	}
+
+	path := filepath.Join(s.directory, name)
+	f, err := os.OpenFile(path+spoolTempSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path + spoolTempSuffix)
+		return err
+	}
+	return os.Rename(path+spoolTempSuffix, path)
+}
+
+// read load the events of a segment.
+func (s *batchSpool) read(name string) ([]Data, error) {
+	content, err := ioutil.ReadFile(filepath.Join(s.directory, name))
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Data, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		d, err := decodeData(line)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, d)
+	}
+	return events, scanner.Err()
+}
+
+// remove delete a segment which has been accepted by the receiver.
+func (s *batchSpool) remove(name string) {
+	if err := os.Remove(filepath.Join(s.directory, name)); err != nil && !os.IsNotExist(err) {
+		s.logger.with(logAttr(LogKeyFile, name), logAttr(LogKeyError, err)).error("remove spool segment failed: %s", err)
+	}
+}
+
+// reject keep a segment which is rejected by the receiver aside, it would not be replayed.
+func (s *batchSpool) reject(name string) {
+	path := filepath.Join(s.directory, name)
+	if err := os.Rename(path, strings.TrimSuffix(path, spoolSegmentSuffix)+spoolRejectedSuffix); err != nil {
+		s.logger.with(logAttr(LogKeyFile, name), logAttr(LogKeyError, err)).error("mark spool segment as rejected failed: %s", err)
+	}
+}
+
+// evict put a segment dropped from the memory cache back to the backlog, it will be loaded again later.
+func (s *batchSpool) evict(name string) {
+	s.mutex.Lock()
+	s.backlog = append(s.backlog, name)
+	sort.Strings(s.backlog)
+	s.mutex.Unlock()
+}
+
+// next load the oldest segment of the backlog.
+func (s *batchSpool) next() (*eventBatch, bool) {
+	for {
+		s.mutex.Lock()
+		if len(s.backlog) == 0 {
+			s.mutex.Unlock()
+			return nil, false
+		}
+		name := s.backlog[0]
+		s.backlog = s.backlog[1:]
+		s.mutex.Unlock()
+
+		events, err := s.read(name)
+		if err != nil {
+			s.logger.with(logAttr(LogKeyFile, name), logAttr(LogKeyError, err)).error("read spool segment %s failed: %s", name, err)
+			s.reject(name)
+			continue
+		}
+		batch := &eventBatch{events: events, segment: name}
+		if len(events) > 0 {
+			batch.appId = events[0].AppId
+		}
+		return batch, true
+	}
+}
@@ -0,0 +1,112 @@
+package thinkingdata_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata/thinkingdatatest"
+)
+
+func newSpoolConsumer(t *testing.T, r *thinkingdatatest.Receiver, spoolDir string, async bool) thinkingdata.TDConsumer {
+	t.Helper()
+	c, err := thinkingdata.NewBatchConsumerWithConfig(thinkingdata.TDBatchConfig{
+		ServerUrl:   r.URL,
+		AppId:       "app",
+		Compress:    true,
+		BatchSize:   2,
+		Async:       async,
+		SenderCount: 1,
+		SpoolDir:    spoolDir,
+		RetryPolicy: &thinkingdata.TDRetryPolicy{MaxAttempts: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func spoolSegments(t *testing.T, spoolDir string) []string {
+	t.Helper()
+	segments, err := filepath.Glob(filepath.Join(spoolDir, "*.seg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segments
+}
+
+func TestSpoolReplay(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		name := "sync"
+		if async {
+			name = "async"
+		}
+		t.Run(name, func(t *testing.T) {
+			r := thinkingdatatest.NewReceiver()
+			defer r.Close()
+			spoolDir := tempDir(t)
+
+			// the receiver is down, the batch stays in the spool
+			r.FailNext(100, http.StatusServiceUnavailable)
+			c := newSpoolConsumer(t, r, spoolDir, async)
+			for _, eventName := range []string{"a", "b", "c"} {
+				c.Add(thinkingdata.Data{AccountId: "account", Type: thinkingdata.Track, EventName: eventName})
+			}
+			if err := c.Flush(); err == nil {
+				t.Fatal("expected an error of the unavailable receiver")
+			}
+			if segments := spoolSegments(t, spoolDir); len(segments) == 0 {
+				t.Fatal("no spool segment is written")
+			}
+			// the data which can't be uploaded on closing stays in the spool as well
+			if err := c.Close(); err == nil {
+				t.Fatal("expected an error of the unavailable receiver")
+			}
+
+			// a new consumer replays the spool after the receiver is back
+			r.FailNext(0, 0)
+			replay := newSpoolConsumer(t, r, spoolDir, async)
+			if err := replay.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			events := r.Events()
+			if len(events) != 3 {
+				t.Fatalf("got %d events, want 3", len(events))
+			}
+			seen := make(map[string]bool)
+			for _, d := range events {
+				seen[d.EventName] = true
+			}
+			if !seen["a"] || !seen["b"] || !seen["c"] {
+				t.Errorf("got events %v", events)
+			}
+			if segments := spoolSegments(t, spoolDir); len(segments) != 0 {
+				t.Errorf("got segments %v after replay, want none", segments)
+			}
+			replay.Close()
+		})
+	}
+}
+
+func TestSpoolRejected(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.SetCode(-2)
+	spoolDir := tempDir(t)
+
+	c := newSpoolConsumer(t, r, spoolDir, false)
+	c.Add(thinkingdata.Data{AccountId: "account", Type: thinkingdata.Track, EventName: "a"})
+	c.Add(thinkingdata.Data{AccountId: "account", Type: thinkingdata.Track, EventName: "b"})
+	c.Close()
+
+	// the rejected batch is kept aside instead of being replayed
+	if segments := spoolSegments(t, spoolDir); len(segments) != 0 {
+		t.Errorf("got segments %v, want none", segments)
+	}
+	files, _ := ioutil.ReadDir(spoolDir)
+	if len(files) != 1 || filepath.Ext(files[0].Name()) != ".rejected" {
+		t.Errorf("got files %v, want one rejected segment", files)
+	}
+}
@@ -0,0 +1,166 @@
+package thinkingdata_test
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata/thinkingdatatest"
+)
+
+func newBatchConsumer(t *testing.T, r *thinkingdatatest.Receiver, config thinkingdata.TDBatchConfig) *thinkingdata.TDBatchConsumer {
+	t.Helper()
+	config.ServerUrl = r.URL
+	config.AppId = "app"
+	config.Compress = true
+	c, err := thinkingdata.NewBatchConsumerWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*thinkingdata.TDBatchConsumer)
+}
+
+func TestRetry(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.FailNext(2, http.StatusServiceUnavailable)
+	c := newBatchConsumer(t, r, thinkingdata.TDBatchConfig{
+		BatchSize:   10,
+		RetryPolicy: &thinkingdata.TDRetryPolicy{MaxAttempts: 3, BaseDelay: 20 * time.Millisecond},
+	})
+	defer c.Close()
+
+	c.Add(trackData("a"))
+	start := time.Now()
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// the delay is doubled for the second retry
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("got %v between the retries, want 60ms at least", elapsed)
+	}
+	if requests := r.Requests(); len(requests) != 3 {
+		t.Fatalf("got %d requests, want 3", len(requests))
+	}
+	if len(r.Events()) != 1 {
+		t.Errorf("got %d events, want 1", len(r.Events()))
+	}
+}
+
+func TestRetryGiveUp(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.FailNext(3, http.StatusServiceUnavailable)
+	c := newBatchConsumer(t, r, thinkingdata.TDBatchConfig{
+		BatchSize:   10,
+		RetryPolicy: &thinkingdata.TDRetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+	defer c.Close()
+
+	c.Add(trackData("a"))
+	err := c.Flush()
+	var receiverErr *thinkingdata.ReceiverError
+	if !errors.As(err, &receiverErr) || receiverErr.StatusCode != http.StatusServiceUnavailable || !receiverErr.Retryable {
+		t.Fatalf("got %v, want a retryable ReceiverError of 503", err)
+	}
+	if requests := r.Requests(); len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+
+	// the batch is kept and uploaded by the next flush
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Events()) != 1 {
+		t.Errorf("got %d events, want 1", len(r.Events()))
+	}
+}
+
+func TestRetryNonRetryableStatus(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.FailNext(1, http.StatusUnauthorized)
+	c := newBatchConsumer(t, r, thinkingdata.TDBatchConfig{
+		BatchSize:   10,
+		RetryPolicy: &thinkingdata.TDRetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	})
+	defer c.Close()
+
+	c.Add(trackData("a"))
+	if err := c.Flush(); !errors.Is(err, thinkingdata.ErrUnexpectedStatus) {
+		t.Fatalf("got %v, want ErrUnexpectedStatus", err)
+	}
+	// not retried at once, but the batch is kept
+	if requests := r.Requests(); len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Events()) != 1 {
+		t.Errorf("got %d events, want 1", len(r.Events()))
+	}
+}
+
+func TestBisectInvalid(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.RejectIf(func(d thinkingdata.Data) bool { return d.EventName == "bad" })
+
+	var mutex sync.Mutex
+	var dropped []thinkingdata.Data
+	directory := tempDir(t)
+	c := newBatchConsumer(t, r, thinkingdata.TDBatchConfig{
+		BatchSize:     10,
+		BisectInvalid: true,
+		DeadLetterDir: directory,
+		RetryPolicy:   &thinkingdata.TDRetryPolicy{MaxAttempts: 1},
+		OnDrop: func(batch []thinkingdata.Data, reason string) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if reason != thinkingdata.DropReasonRejected {
+				t.Errorf("got drop reason %s", reason)
+			}
+			dropped = append(dropped, batch...)
+		},
+	})
+	defer c.Close()
+
+	for i := 0; i < 8; i++ {
+		name := "good" + strconv.Itoa(i)
+		if i == 5 {
+			name = "bad"
+		}
+		c.Add(trackData(name))
+	}
+	if err := c.Flush(); !errors.Is(err, thinkingdata.ErrInvalidDataFormat) {
+		t.Fatalf("got %v, want ErrInvalidDataFormat", err)
+	}
+
+	// only the invalid event is rejected, the others are uploaded in the halves
+	names := eventNames(r.Events())
+	if len(names) != 7 || names["bad"] != 0 {
+		t.Errorf("got events %v", names)
+	}
+	mutex.Lock()
+	if len(dropped) != 1 || dropped[0].EventName != "bad" {
+		t.Errorf("got dropped %+v", dropped)
+	}
+	mutex.Unlock()
+	if fallbackFiles(t, directory) == 0 {
+		t.Error("the invalid event is not written to the dead-letter directory")
+	}
+
+	// nothing is left to upload
+	r.Reset()
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Requests()) != 0 {
+		t.Errorf("got %d requests, want 0", len(r.Requests()))
+	}
+}
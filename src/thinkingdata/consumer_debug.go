@@ -1,12 +1,13 @@
 package thinkingdata
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
 // TDDebugConsumer The data is reported one by one, and when an error occurs, the log will be printed on the console.
@@ -15,6 +16,18 @@ type TDDebugConsumer struct {
 	appId     string // appId
 	writeData bool   // is archive to TE
 	deviceId  string // be used to debug in TE
+	logger    *instanceLogger
+	metrics   TDMetrics
+}
+
+type TDDebugConfig struct {
+	ServerUrl string     // serverUrl
+	AppId     string     // appId
+	DryRun    bool       // the data is only checked by the receiver, not archived to TE
+	DeviceId  string     // be used to debug in TE
+	Logger    TDLogger   // logger of the consumer, the logger set by SetCustomLogger is used when nil
+	LogLevel  TDLogLevel // log level of the consumer, TDLogLevelDebug is used when 0. The package level is not changed
+	Metrics   TDMetrics  // receiver of the metrics of the consumer, they are discarded when nil
 }
 
 // NewDebugConsumer init TDDebugConsumer
@@ -30,52 +43,86 @@ func NewDebugConsumerWithDeviceId(serverUrl string, appId string, writeData bool
 	// enable console log
 	SetLogLevel(TDLogLevelDebug)
 
-	if len(serverUrl) <= 0 {
-		msg := fmt.Sprint("ServerUrl not be empty")
-		tdLogError(msg)
-		return nil, errors.New(msg)
+	return NewDebugConsumerWithConfig(TDDebugConfig{
+		ServerUrl: serverUrl,
+		AppId:     appId,
+		DryRun:    !writeData,
+		DeviceId:  deviceId,
+	})
+}
+
+// NewDebugConsumerWithConfig init TDDebugConsumer, unlike the other constructors the package level log level is not changed.
+func NewDebugConsumerWithConfig(config TDDebugConfig) (TDConsumer, error) {
+	logLevel := config.LogLevel
+	if logLevel == 0 {
+		logLevel = TDLogLevelDebug
+	}
+	logger := newInstanceLogger(config.Logger, logLevel)
+
+	if len(config.ServerUrl) <= 0 {
+		logger.error(ErrEmptyServerUrl.Error())
+		return nil, ErrEmptyServerUrl
 	}
 
-	u, err := url.Parse(serverUrl)
+	u, err := url.Parse(config.ServerUrl)
 	if err != nil {
 		return nil, err
 	}
 
 	u.Path = "/data_debug"
 
-	c := &TDDebugConsumer{serverUrl: u.String(), appId: appId, writeData: writeData, deviceId: deviceId}
+	c := &TDDebugConsumer{serverUrl: u.String(), appId: config.AppId, writeData: !config.DryRun, deviceId: config.DeviceId, logger: logger, metrics: normalizeMetrics(config.Metrics)}
 
-	tdLogInfo("Mode: debug consumer, appId: %s, serverUrl: %s", c.appId, c.serverUrl)
+	c.logger.with(logAttr(LogKeyAppId, c.appId)).info("Mode: debug consumer, appId: %s, serverUrl: %s", c.appId, c.serverUrl)
 
 	return c, nil
 }
 
 func (c *TDDebugConsumer) Add(d Data) error {
-	jsonBytes, err := json.Marshal(d)
+	return c.AddCtx(context.Background(), d)
+}
+
+// AddCtx report the data immediately, the http request is canceled when the context is done.
+func (c *TDDebugConsumer) AddCtx(ctx context.Context, d Data) error {
+	jsonBytes, err := MarshalData(d)
 	if err != nil {
 		return err
 	}
+	jsonStr := string(jsonBytes)
 
-	var jsonStr string
-	// if properties has includes complex data, SDK need parse time with regular expression
-	if d.IsComplex {
-		jsonStr = parseTime(jsonBytes)
-	} else {
-		jsonStr = string(jsonBytes)
-	}
+	c.logger.info("%v", jsonStr)
 
-	tdLogInfo("%v", jsonStr)
+	start := time.Now()
+	err = c.send(ctx, jsonStr)
+	c.metrics.Histogram(MetricSendDuration, time.Since(start).Seconds(), c.metricLabels()...)
+	if err != nil {
+		c.metrics.Counter(MetricBatchesFailed, 1, c.metricLabels()...)
+		return err
+	}
+	c.metrics.Counter(MetricEventsSent, 1, c.metricLabels()...)
+	c.metrics.Counter(MetricBatchesSent, 1, c.metricLabels()...)
+	return nil
+}
 
-	return c.send(jsonStr)
+func (c *TDDebugConsumer) metricLabels() []TDMetricLabel {
+	return []TDMetricLabel{metricLabel(MetricLabelConsumer, "debug"), metricLabel(MetricLabelAppId, c.appId)}
 }
 
 func (c *TDDebugConsumer) Flush() error {
-	tdLogInfo("flush data")
+	return c.FlushCtx(context.Background())
+}
+
+func (c *TDDebugConsumer) FlushCtx(ctx context.Context) error {
+	c.logger.info("flush data")
 	return nil
 }
 
 func (c *TDDebugConsumer) Close() error {
-	tdLogInfo("debug consumer close")
+	return c.CloseCtx(context.Background())
+}
+
+func (c *TDDebugConsumer) CloseCtx(ctx context.Context) error {
+	c.logger.info("debug consumer close")
 	return nil
 }
 
@@ -83,7 +130,7 @@ func (c *TDDebugConsumer) IsStringent() bool {
 	return true
 }
 
-func (c *TDDebugConsumer) send(data string) error {
+func (c *TDDebugConsumer) send(ctx context.Context, data string) error {
 	var dryRun = "0"
 	if !c.writeData {
 		dryRun = "1"
@@ -92,10 +139,17 @@ func (c *TDDebugConsumer) send(data string) error {
 	if len(c.deviceId) > 0 {
 		postData.Add("deviceId", c.deviceId)
 	}
-	resp, err := http.PostForm(c.serverUrl, postData)
+	encoded := postData.Encode()
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverUrl, strings.NewReader(encoded))
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	c.metrics.Counter(MetricBytesSent, int64(len(encoded)), c.metricLabels()...)
 
 	defer resp.Body.Close()
 
@@ -106,15 +160,15 @@ func (c *TDDebugConsumer) send(data string) error {
 		if err != nil {
 			return err
 		}
-		if uint64(result["errorLevel"].(float64)) != 0 {
-			msg := fmt.Sprintf("send to receiver failed with return content:  %s", string(body))
-			tdLogError(msg)
-			return errors.New(msg)
+		if errorLevel, _ := result["errorLevel"].(float64); errorLevel != 0 {
+			err = &ReceiverError{StatusCode: resp.StatusCode, Code: int(errorLevel), BatchSize: 1, AppId: c.appId, Body: string(body), Err: ErrInvalidDataFormat}
+			c.logger.with(receiverErrorAttrs(err)...).error("send to receiver failed with return content:  %s", string(body))
+			return err
 		} else {
-			tdLogInfo("send success: %v", result)
+			c.logger.with(logAttr(LogKeyAppId, c.appId), logAttr(LogKeyStatusCode, resp.StatusCode)).info("send success: %v", result)
 		}
 	} else {
-		return errors.New(fmt.Sprintf("Unexpected Status Code: %d", resp.StatusCode))
+		return &ReceiverError{StatusCode: resp.StatusCode, BatchSize: 1, AppId: c.appId, Err: ErrUnexpectedStatus}
 	}
 	return nil
 }
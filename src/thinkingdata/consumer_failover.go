@@ -0,0 +1,356 @@
+package thinkingdata
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const DefaultRecoverInterval = 30
+
+// suffix of the fallback files which are being uploaded, new data is written to other files meanwhile
+const fallbackResendSuffix = ".resend"
+
+// TDFailoverConsumer upload data by TDBatchConsumer while the receiver is healthy. When the receiver is unreachable,
+// data is diverted to files in the format of TDLogConsumer, and the files are uploaded in background once
+// the receiver is healthy again.
+type TDFailoverConsumer struct {
+	primary         *TDBatchConsumer
+	fallbackConfig  TDLogConsumerConfig
+	fallback        *TDLogConsumer // nil while data goes to the primary consumer
+	recoverInterval time.Duration
+	mutex           *sync.RWMutex
+	healthy         bool
+	logger          *instanceLogger
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+type TDFailoverConsumerConfig struct {
+	BatchConfig     TDBatchConfig       // config of the primary TDBatchConsumer
+	LogConfig       TDLogConsumerConfig // config of the fallback files
+	RecoverInterval int                 // spacing of checking the receiver and uploading the fallback files (second)
+}
+
+// NewFailoverConsumer create TDFailoverConsumer, data is diverted to the directory when the receiver is unreachable.
+func NewFailoverConsumer(batchConfig TDBatchConfig, directory string) (TDConsumer, error) {
+	return NewFailoverConsumerWithConfig(TDFailoverConsumerConfig{
+		BatchConfig: batchConfig,
+		LogConfig:   TDLogConsumerConfig{Directory: directory, RotateMode: ROTATE_HOURLY},
+	})
+}
+
+func NewFailoverConsumerWithConfig(config TDFailoverConsumerConfig) (TDConsumer, error) {
+	interval := config.RecoverInterval
+	if interval <= 0 {
+		interval = DefaultRecoverInterval
+	}
+
+	c := &TDFailoverConsumer{
+		fallbackConfig:  config.LogConfig,
+		recoverInterval: time.Duration(interval) * time.Second,
+		mutex:           new(sync.RWMutex),
+		healthy:         true,
+		logger:          newInstanceLogger(config.BatchConfig.Logger, config.BatchConfig.LogLevel),
+		stop:            make(chan struct{}),
+	}
+
+	batchConfig := config.BatchConfig
+	// the errors of the async uploads and the timer flushes are not returned to AddCtx and FlushCtx
+	batchConfig.onUnavailable = c.onUnavailable
+	primary, err := initBatchConsumer(batchConfig)
+	if err != nil {
+		return nil, err
+	}
+	// the lock orders the assignment before the calls of onUnavailable from the goroutines of the primary consumer
+	c.mutex.Lock()
+	c.primary = primary.(*TDBatchConsumer)
+	c.mutex.Unlock()
+
+	c.wg.Add(1)
+	go c.run()
+
+	c.logger.info("Mode: failover consumer, fallback path: %s", c.fallbackConfig.Directory)
+	return c, nil
+}
+
+func (c *TDFailoverConsumer) Add(d Data) error {
+	return c.AddCtx(context.Background(), d)
+}
+
+// AddCtx write data to the fallback files while they are open, otherwise to the primary consumer.
+func (c *TDFailoverConsumer) AddCtx(ctx context.Context, d Data) error {
+	c.mutex.RLock()
+	if c.fallback != nil {
+		err := c.fallback.AddCtx(ctx, d)
+		c.mutex.RUnlock()
+		return err
+	}
+	c.mutex.RUnlock()
+
+	err := c.primary.AddCtx(ctx, d)
+	if isUnavailable(ctx, err) {
+		return c.failover(ctx)
+	}
+	return err
+}
+
+func (c *TDFailoverConsumer) Flush() error {
+	return c.FlushCtx(context.Background())
+}
+
+func (c *TDFailoverConsumer) FlushCtx(ctx context.Context) error {
+	c.mutex.RLock()
+	if c.fallback != nil {
+		err := c.fallback.FlushCtx(ctx)
+		c.mutex.RUnlock()
+		return err
+	}
+	c.mutex.RUnlock()
+
+	err := c.primary.FlushCtx(ctx)
+	if isUnavailable(ctx, err) {
+		return c.failover(ctx)
+	}
+	return err
+}
+
+func (c *TDFailoverConsumer) Close() error {
+	return c.CloseCtx(context.Background())
+}
+
+func (c *TDFailoverConsumer) CloseCtx(ctx context.Context) error {
+	c.logger.info("failover consumer close")
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.wg.Wait()
+
+	c.mutex.RLock()
+	healthy := c.healthy
+	c.mutex.RUnlock()
+
+	var err error
+	if healthy {
+		err = c.primary.CloseCtx(ctx)
+		if isUnavailable(ctx, err) {
+			err = c.failover(ctx)
+		}
+	} else {
+		err = c.failover(ctx)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.fallback != nil {
+		if closeErr := c.fallback.CloseCtx(ctx); err == nil {
+			err = closeErr
+		}
+		c.fallback = nil
+	}
+	return err
+}
+
+func (c *TDFailoverConsumer) IsStringent() bool {
+	return false
+}
+
+// isUnavailable report whether the error means that the receiver can't be reached.
+func isUnavailable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var receiverErr *ReceiverError
+	if errors.As(err, &receiverErr) {
+		return receiverErr.Retryable
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// onUnavailable fail over when an upload of the primary consumer can't reach the receiver.
+func (c *TDFailoverConsumer) onUnavailable(err error) {
+	c.logger.with(logAttr(LogKeyError, err)).warning("upload failed: %s", err)
+	if failoverErr := c.failover(context.Background()); failoverErr != nil {
+		c.logger.with(logAttr(LogKeyError, failoverErr)).error("fail over failed: %s", failoverErr)
+	}
+}
+
+// failover divert data to the fallback files, the data kept by the primary consumer is moved as well.
+func (c *TDFailoverConsumer) failover(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.fallback == nil {
+		if err := c.openFallback(); err != nil {
+			return err
+		}
+	}
+	if c.healthy {
+		c.logger.with(logAttr(LogKeyFile, c.fallbackConfig.Directory)).warning("receiver is unavailable, divert data to %s", c.fallbackConfig.Directory)
+		c.healthy = false
+	}
+
+	for _, d := range c.primary.drain() {
+		if err := c.fallback.AddCtx(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openFallback create the fallback TDLogConsumer, c.mutex must be held by the caller.
+func (c *TDFailoverConsumer) openFallback() error {
+	fallback, err := NewLogConsumerWithConfig(c.fallbackConfig)
+	if err != nil {
+		c.logger.error("open fallback files failed: %s", err)
+		return err
+	}
+	c.fallback = fallback.(*TDLogConsumer)
+	return nil
+}
+
+func (c *TDFailoverConsumer) run() {
+	defer c.wg.Done()
+	// the files left by the last run are uploaded at once
+	_ = c.resend()
+
+	ticker := time.NewTicker(c.recoverInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			_ = c.resend()
+		}
+	}
+}
+
+// resend upload the fallback files, the consumer turns back to the primary consumer when all of them are uploaded.
+// The files are renamed with fallbackResendSuffix under the lock, and uploaded without it, so that data can be added
+// meanwhile. It goes to the primary consumer, which fails over to new fallback files if the receiver is still unavailable.
+func (c *TDFailoverConsumer) resend() error {
+	c.mutex.Lock()
+	if c.fallback != nil {
+		// close the current file to upload it as well
+		if err := c.fallback.Close(); err != nil {
+			c.logger.error("close fallback files failed: %s", err)
+		}
+		c.fallback = nil
+	}
+	files, err := c.takeFallbackFiles()
+	c.mutex.Unlock()
+
+	if err == nil {
+		for _, name := range files {
+			if err = c.resendFile(name); err != nil {
+				break
+			}
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		c.healthy = false
+		if c.fallback == nil {
+			if openErr := c.openFallback(); openErr != nil {
+				return openErr
+			}
+		}
+		return err
+	}
+	if !c.healthy && c.fallback == nil {
+		c.logger.info("receiver is available again, fallback files are uploaded")
+		c.healthy = true
+	}
+	return nil
+}
+
+// takeFallbackFiles rename the fallback files with fallbackResendSuffix, and return all the files to upload,
+// the ones left by the last resend first. c.mutex must be held by the caller.
+func (c *TDFailoverConsumer) takeFallbackFiles() ([]string, error) {
+	directory := c.fallbackConfig.Directory
+	pattern := "log.*" + fallbackResendSuffix
+	if len(c.fallbackConfig.FileNamePrefix) > 0 {
+		pattern = c.fallbackConfig.FileNamePrefix + "." + pattern
+	}
+	pending, err := filepath.Glob(filepath.Join(directory, pattern))
+	if err != nil {
+		c.logger.error("list fallback files failed: %s", err)
+		return nil, err
+	}
+	sort.Strings(pending)
+
+	files, err := listLogFiles(directory, c.fallbackConfig.FileNamePrefix)
+	if err != nil && !os.IsNotExist(err) {
+		c.logger.error("list fallback files failed: %s", err)
+		return nil, err
+	}
+	for _, name := range files {
+		if err := os.Rename(name, name+fallbackResendSuffix); err != nil {
+			c.logger.with(logAttr(LogKeyFile, name), logAttr(LogKeyError, err)).error("rename fallback file failed: %s", err)
+			return nil, err
+		}
+		pending = append(pending, name+fallbackResendSuffix)
+	}
+	return pending, nil
+}
+
+// resendFile upload the lines of a fallback file and delete it. If the receiver can't be reached,
+// the file is rewritten with the lines which are not uploaded yet.
+func (c *TDFailoverConsumer) resendFile(name string) error {
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		c.logger.with(logAttr(LogKeyFile, name), logAttr(LogKeyError, err)).error("read fallback file failed: %s", err)
+		return err
+	}
+
+	lines := bytes.Split(content, []byte("\n"))
+	events := make([]Data, 0, len(lines))
+	for _, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		d, err := decodeData(line)
+		if err != nil {
+			c.logger.with(logAttr(LogKeyFile, name), logAttr(LogKeyError, err)).error("invalid line in fallback file %s: %s", name, err)
+			continue
+		}
+		events = append(events, d)
+	}
+
+	sent := 0
+	err = c.primary.uploadEvents(context.Background(), events, func(n int) { sent = n })
+	if err != nil {
+		if rewriteErr := rewriteEvents(name, events[sent:]); rewriteErr != nil {
+			c.logger.with(logAttr(LogKeyFile, name), logAttr(LogKeyError, rewriteErr)).error("rewrite fallback file failed: %s", rewriteErr)
+		}
+		return err
+	}
+	return os.Remove(name)
+}
+
+func rewriteEvents(name string, events []Data) error {
+	var buf bytes.Buffer
+	for _, d := range events {
+		jsonBytes, err := MarshalData(d)
+		if err != nil {
+			return err
+		}
+		buf.Write(jsonBytes)
+		buf.WriteByte('\n')
+	}
+	if err := ioutil.WriteFile(name+spoolTempSuffix, buf.Bytes(), 0664); err != nil {
+		return err
+	}
+	return os.Rename(name+spoolTempSuffix, name)
+}
@@ -0,0 +1,92 @@
+package thinkingdata_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata/thinkingdatatest"
+)
+
+func newFailoverConsumer(t *testing.T, r *thinkingdatatest.Receiver, directory string, batchConfig thinkingdata.TDBatchConfig) thinkingdata.TDConsumer {
+	t.Helper()
+	batchConfig.ServerUrl = r.URL
+	batchConfig.AppId = "app"
+	batchConfig.Compress = true
+	batchConfig.RetryPolicy = &thinkingdata.TDRetryPolicy{MaxAttempts: 1}
+	c, err := thinkingdata.NewFailoverConsumerWithConfig(thinkingdata.TDFailoverConsumerConfig{
+		BatchConfig:     batchConfig,
+		LogConfig:       thinkingdata.TDLogConsumerConfig{Directory: directory, RotateMode: thinkingdata.ROTATE_HOURLY},
+		RecoverInterval: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func fallbackFiles(t *testing.T, directory string) int {
+	t.Helper()
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func eventNames(events []thinkingdata.Data) map[string]int {
+	names := make(map[string]int)
+	for _, d := range events {
+		names[d.EventName]++
+	}
+	return names
+}
+
+func TestFailoverRecover(t *testing.T) {
+	cases := []struct {
+		name   string
+		config thinkingdata.TDBatchConfig
+	}{
+		{"sync", thinkingdata.TDBatchConfig{BatchSize: 2}},
+		// the errors of the senders are not returned by Add
+		{"async", thinkingdata.TDBatchConfig{BatchSize: 2, Async: true, SenderCount: 1}},
+		// the errors of the timer are not returned to anyone
+		{"timer", thinkingdata.TDBatchConfig{BatchSize: 100, AutoFlush: true, Interval: 1}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := thinkingdatatest.NewReceiver()
+			defer r.Close()
+			r.FailNext(1000, http.StatusServiceUnavailable)
+			directory := tempDir(t)
+			consumer := newFailoverConsumer(t, r, directory, c.config)
+			defer consumer.Close()
+
+			consumer.Add(trackData("a"))
+			consumer.Add(trackData("b"))
+			// the data goes to the fallback files once an upload fails
+			waitFor(t, 5*time.Second, func() bool { return fallbackFiles(t, directory) > 0 })
+			consumer.Add(trackData("c"))
+
+			// the fallback files are uploaded after the receiver is back
+			r.FailNext(0, 0)
+			waitFor(t, 5*time.Second, func() bool { return len(r.Events()) == 3 })
+			waitFor(t, 5*time.Second, func() bool { return fallbackFiles(t, directory) == 0 })
+			names := eventNames(r.Events())
+			if names["a"] != 1 || names["b"] != 1 || names["c"] != 1 {
+				t.Errorf("got events %v", names)
+			}
+
+			// back to the primary consumer
+			consumer.Add(trackData("d"))
+			if err := consumer.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if names := eventNames(r.Events()); names["d"] != 1 || fallbackFiles(t, directory) != 0 {
+				t.Errorf("got events %v, want d uploaded by the primary consumer", names)
+			}
+		})
+	}
+}
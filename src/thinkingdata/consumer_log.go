@@ -1,10 +1,14 @@
 package thinkingdata
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -28,6 +32,8 @@ type TDLogConsumer struct {
 	ch             chan []byte
 	mutex          *sync.RWMutex
 	sdkClose       bool
+	logger         *instanceLogger
+	metrics        TDMetrics
 }
 
 type TDLogConsumerConfig struct {
@@ -36,6 +42,9 @@ type TDLogConsumerConfig struct {
 	FileSize       int        // max size of single log file (MByte)
 	FileNamePrefix string     // prefix of log file
 	ChannelSize    int
+	Logger         TDLogger   // logger of the consumer, the logger set by SetCustomLogger is used when nil
+	LogLevel       TDLogLevel // log level of the consumer, the level set by SetLogLevel is used when 0
+	Metrics        TDMetrics  // receiver of the metrics of the consumer, they are discarded when nil
 }
 
 func NewLogConsumer(directory string, r RotateMode) (TDConsumer, error) {
@@ -56,6 +65,7 @@ func NewLogConsumerWithFileSize(directory string, r RotateMode, size int) (TDCon
 }
 
 func NewLogConsumerWithConfig(config TDLogConsumerConfig) (TDConsumer, error) {
+	logger := newInstanceLogger(config.Logger, config.LogLevel)
 	var df string
 	switch config.RotateMode {
 	case ROTATE_DAILY:
@@ -63,9 +73,8 @@ func NewLogConsumerWithConfig(config TDLogConsumerConfig) (TDConsumer, error) {
 	case ROTATE_HOURLY:
 		df = "2006-01-02-15"
 	default:
-		errStr := "unknown rotate mode"
-		tdLogInfo(errStr)
-		return nil, errors.New(errStr)
+		logger.info(ErrUnknownRotateMode.Error())
+		return nil, ErrUnknownRotateMode
 	}
 
 	chanSize := DefaultChannelSize
@@ -82,34 +91,52 @@ func NewLogConsumerWithConfig(config TDLogConsumerConfig) (TDConsumer, error) {
 		ch:             make(chan []byte, chanSize),
 		mutex:          new(sync.RWMutex),
 		sdkClose:       false,
+		logger:         logger,
+		metrics:        normalizeMetrics(config.Metrics),
 	}
 
+	c.metrics.Gauge(MetricQueueCapacity, float64(chanSize), c.metricLabels()...)
 	return c, c.init()
 }
 
 func (c *TDLogConsumer) Add(d Data) error {
+	return c.AddCtx(context.Background(), d)
+}
+
+// AddCtx write data to the channel, it gives up waiting for a free slot when the context is done.
+func (c *TDLogConsumer) AddCtx(ctx context.Context, d Data) error {
 	var err error = nil
 	c.mutex.Lock()
 	if c.sdkClose {
-		err = errors.New("add event failed, SDK has been closed")
+		err = fmt.Errorf("add event failed: %w", ErrConsumerClosed)
 	}
 	c.mutex.Unlock()
 	if err != nil {
-		tdLogError(err.Error())
+		c.logger.error(err.Error())
 		return err
 	}
 
-	jsonBytes, jsonErr := json.Marshal(d)
+	jsonBytes, jsonErr := MarshalData(d)
 	if jsonErr != nil {
 		err = jsonErr
 	} else {
-		c.ch <- jsonBytes
+		select {
+		case c.ch <- jsonBytes:
+			c.metrics.Counter(MetricEventsEnqueued, 1, c.metricLabels()...)
+			c.reportQueueDepth()
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
 	}
 	return err
 }
 
 func (c *TDLogConsumer) Flush() error {
-	tdLogInfo("flush data")
+	return c.FlushCtx(context.Background())
+}
+
+func (c *TDLogConsumer) FlushCtx(ctx context.Context) error {
+	c.logger.info("flush data")
 	var err error = nil
 	c.mutex.Lock()
 	if c.currentFile != nil {
@@ -120,17 +147,36 @@ func (c *TDLogConsumer) Flush() error {
 }
 
 func (c *TDLogConsumer) Close() error {
-	tdLogInfo("log consumer close")
+	return c.CloseCtx(context.Background())
+}
+
+func (c *TDLogConsumer) CloseCtx(ctx context.Context) error {
+	c.logger.info("log consumer close")
 
 	var err error = nil
 	c.mutex.Lock()
 	if c.sdkClose {
-		err = errors.New("[ThinkingData][error]: SDK has been closed")
+		err = ErrConsumerClosed
 	} else {
 		c.sdkClose = true
 		close(c.ch)
 	}
 	c.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// wait for the remaining data to be written
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
 	return err
 }
 
@@ -154,19 +200,21 @@ func (c *TDLogConsumer) constructFileName(timeStr string, i int) string {
 func (c *TDLogConsumer) init() error {
 	fd, err := c.initLogFile()
 	if err != nil {
-		tdLogError("init log file failed: %s", err)
+		c.logger.error("init log file failed: %s", err)
 		return err
 	}
 	c.currentFile = fd
 
+	c.wg.Add(1)
 	go func() {
+		defer c.wg.Done()
 		defer func() {
 			if c.currentFile != nil {
 				_ = c.currentFile.Sync()
 				err = c.currentFile.Close()
 				c.currentFile = nil
 			}
-			tdLogInfo("Gracefully shutting down")
+			c.logger.info("Gracefully shutting down")
 		}()
 		for {
 			select {
@@ -174,14 +222,19 @@ func (c *TDLogConsumer) init() error {
 				if !ok {
 					return
 				}
-				jsonStr := parseTime(rec)
-				tdLogInfo("write event data: %s", jsonStr)
-				c.writeToFile(jsonStr)
+				c.reportQueueDepth()
+				jsonStr := string(rec)
+				c.logger.info("write event data: %s", jsonStr)
+				if c.writeToFile(jsonStr) {
+					c.metrics.Counter(MetricEventsWritten, 1, c.metricLabels()...)
+				} else {
+					c.metrics.Counter(MetricWriteErrors, 1, c.metricLabels()...)
+				}
 			}
 		}
 	}()
 
-	tdLogInfo("Mode: log consumer, log path: " + c.directory)
+	c.logger.info("Mode: log consumer, log path: " + c.directory)
 
 	return nil
 }
@@ -198,9 +251,18 @@ func (c *TDLogConsumer) initLogFile() (*os.File, error) {
 	return os.OpenFile(c.constructFileName(timeStr, 0), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
 }
 
+func (c *TDLogConsumer) metricLabels() []TDMetricLabel {
+	return []TDMetricLabel{metricLabel(MetricLabelConsumer, "log")}
+}
+
+func (c *TDLogConsumer) reportQueueDepth() {
+	c.metrics.Gauge(MetricQueueDepth, float64(len(c.ch)), c.metricLabels()...)
+}
+
 var logFileIndex = 0
 
-func (c *TDLogConsumer) writeToFile(str string) {
+// writeToFile write a line to the current file, it returns false when the line is not written.
+func (c *TDLogConsumer) writeToFile(str string) bool {
 	timeStr := time.Now().Format(c.dateFormat)
 	// paging by Rotate Mode and current file size
 	var newName string
@@ -212,8 +274,8 @@ func (c *TDLogConsumer) writeToFile(str string) {
 		c.currentFile, openFileErr = os.OpenFile(fName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
 		c.mutex.Unlock()
 		if openFileErr != nil {
-			tdLogInfo("open log file failed: %s\n", openFileErr)
-			return
+			c.logger.info("open log file failed: %s\n", openFileErr)
+			return false
 		}
 	}
 
@@ -230,22 +292,74 @@ func (c *TDLogConsumer) writeToFile(str string) {
 		_ = c.currentFile.Sync()
 		err := c.currentFile.Close()
 		if err != nil {
-			tdLogInfo("close file failed: %s\n", err)
-			return
+			c.logger.info("close file failed: %s\n", err)
+			return false
 		}
 		c.mutex.Lock()
 		c.currentFile, err = os.OpenFile(fName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
 		c.mutex.Unlock()
 		if err != nil {
-			tdLogInfo("rotate log file failed: %s\n", err)
-			return
+			c.logger.info("rotate log file failed: %s\n", err)
+			return false
 		}
 	}
 	_, err := fmt.Fprintln(c.currentFile, str)
 	if err != nil {
-		tdLogInfo("LoggerWriter(%q): %s\n", c.currentFile.Name(), err)
-		return
+		c.logger.info("LoggerWriter(%q): %s\n", c.currentFile.Name(), err)
+		return false
+	}
+	return true
+}
+
+// listLogFiles list the files written by TDLogConsumer in the directory, ordered by time and page index.
+func listLogFiles(directory, fileNamePrefix string) ([]string, error) {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	prefix := "log."
+	if len(fileNamePrefix) != 0 {
+		prefix = fileNamePrefix + "." + prefix
+	}
+
+	type logFile struct {
+		name    string
+		timeStr string
+		index   int
+	}
+	logFiles := make([]logFile, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), prefix) {
+			continue
+		}
+		// name format: [prefix.]log.timeStr[_index]
+		lf := logFile{name: f.Name(), timeStr: strings.TrimPrefix(f.Name(), prefix)}
+		if idx := strings.LastIndex(lf.timeStr, "_"); idx >= 0 {
+			index, err := strconv.Atoi(lf.timeStr[idx+1:])
+			if err != nil {
+				continue
+			}
+			lf.timeStr, lf.index = lf.timeStr[:idx], index
+		}
+		if _, err := time.Parse("2006-01-02", lf.timeStr); err != nil {
+			if _, err := time.Parse("2006-01-02-15", lf.timeStr); err != nil {
+				continue
+			}
+		}
+		logFiles = append(logFiles, lf)
+	}
+	sort.Slice(logFiles, func(i, j int) bool {
+		if logFiles[i].timeStr != logFiles[j].timeStr {
+			return logFiles[i].timeStr < logFiles[j].timeStr
+		}
+		return logFiles[i].index < logFiles[j].index
+	})
+
+	names := make([]string, 0, len(logFiles))
+	for _, f := range logFiles {
+		names = append(names, filepath.Join(directory, f.name))
 	}
+	return names, nil
 }
 
 // Deprecated: please use TDLogConsumer
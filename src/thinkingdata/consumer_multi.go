@@ -0,0 +1,159 @@
+package thinkingdata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type TDMultiMode int32
+
+const (
+	MultiModeAllMustSucceed TDMultiMode = 0 // an error is returned when any of the consumers fails
+	MultiModeBestEffort     TDMultiMode = 1 // an error is returned only when all the consumers fail
+)
+
+// TDMultiConsumer write data to several consumers, e.g. TDLogConsumer and TDBatchConsumer at the same time.
+// Every consumer gets the data even if the ones before it have failed.
+type TDMultiConsumer struct {
+	consumers []TDConsumer
+	mode      TDMultiMode
+	logger    *instanceLogger
+}
+
+type TDMultiConsumerConfig struct {
+	Consumers []TDConsumer // child consumers, in the order of calling
+	Mode      TDMultiMode  // how the errors of child consumers are reported
+	Logger    TDLogger     // logger of the consumer, the logger set by SetCustomLogger is used when nil
+	LogLevel  TDLogLevel   // log level of the consumer, the level set by SetLogLevel is used when 0
+}
+
+// MultiError aggregated errors of the child consumers of TDMultiConsumer.
+// errors.Is and errors.As check each of the errors, by the methods Is and As before Go 1.20.
+type MultiError struct {
+	Errors []error
+}
+
+func (e *MultiError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d consumer(s) failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+func (e *MultiError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is report whether any of the errors matches target
+func (e *MultiError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As find the first of the errors that matches target, and set target to it
+func (e *MultiError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewMultiConsumer create TDMultiConsumer, all the consumers must succeed.
+func NewMultiConsumer(consumers ...TDConsumer) (TDConsumer, error) {
+	return NewMultiConsumerWithConfig(TDMultiConsumerConfig{Consumers: consumers})
+}
+
+func NewMultiConsumerWithConfig(config TDMultiConsumerConfig) (TDConsumer, error) {
+	logger := newInstanceLogger(config.Logger, config.LogLevel)
+	consumers := make([]TDConsumer, 0, len(config.Consumers))
+	for _, c := range config.Consumers {
+		if c != nil {
+			consumers = append(consumers, c)
+		}
+	}
+	if len(consumers) == 0 {
+		err := fmt.Errorf("%w: consumers not be empty", ErrInvalidParams)
+		logger.error(err.Error())
+		return nil, err
+	}
+	if config.Mode != MultiModeAllMustSucceed && config.Mode != MultiModeBestEffort {
+		err := fmt.Errorf("%w: unknown multi consumer mode %d", ErrInvalidParams, config.Mode)
+		logger.error(err.Error())
+		return nil, err
+	}
+
+	c := &TDMultiConsumer{consumers: consumers, mode: config.Mode, logger: logger}
+	c.logger.info("Mode: multi consumer, count of consumers: %d", len(consumers))
+	return c, nil
+}
+
+func (c *TDMultiConsumer) Add(d Data) error {
+	return c.AddCtx(context.Background(), d)
+}
+
+func (c *TDMultiConsumer) AddCtx(ctx context.Context, d Data) error {
+	return c.each(func(child TDConsumer) error {
+		return addToConsumer(ctx, child, d)
+	})
+}
+
+func (c *TDMultiConsumer) Flush() error {
+	return c.FlushCtx(context.Background())
+}
+
+func (c *TDMultiConsumer) FlushCtx(ctx context.Context) error {
+	c.logger.info("flush data")
+	return c.each(func(child TDConsumer) error {
+		return flushConsumer(ctx, child)
+	})
+}
+
+func (c *TDMultiConsumer) Close() error {
+	return c.CloseCtx(context.Background())
+}
+
+func (c *TDMultiConsumer) CloseCtx(ctx context.Context) error {
+	c.logger.info("multi consumer close")
+	return c.each(func(child TDConsumer) error {
+		return closeConsumer(ctx, child)
+	})
+}
+
+// IsStringent data is checked strictly if any of the consumers requires it.
+func (c *TDMultiConsumer) IsStringent() bool {
+	for _, child := range c.consumers {
+		if child.IsStringent() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *TDMultiConsumer) each(action func(child TDConsumer) error) error {
+	var errs []error
+	for _, child := range c.consumers {
+		if err := action(child); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	err := &MultiError{Errors: errs}
+	if c.mode == MultiModeBestEffort && len(errs) < len(c.consumers) {
+		c.logger.with(logAttr(LogKeyError, err)).warning(err.Error())
+		return nil
+	}
+	c.logger.with(logAttr(LogKeyError, err)).error(err.Error())
+	return err
+}
@@ -0,0 +1,600 @@
+package thinkingdata
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MarshalData encode the data to JSON as the consumers do. The output is the same as encoding/json,
+// except that time.Time values at any depth of properties are written in DATE_FORMAT.
+// A cyclic value is an error, as it is for encoding/json.
+func MarshalData(d Data) ([]byte, error) {
+	return appendData(make([]byte, 0, 256), &d)
+}
+
+// marshalDataList encode the data to a JSON array, which is the body of /sync_server.
+func marshalDataList(list []Data) ([]byte, error) {
+	b := make([]byte, 0, 256*len(list)+2)
+	b = append(b, '[')
+	var err error
+	for i := range list {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		if b, err = appendData(b, &list[i]); err != nil {
+			return nil, err
+		}
+	}
+	return append(b, ']'), nil
+}
+
+func appendData(b []byte, d *Data) ([]byte, error) {
+	b = append(b, '{')
+	start := len(b)
+	b = appendField(b, start, "#account_id", d.AccountId, true)
+	b = appendField(b, start, "#distinct_id", d.DistinctId, true)
+	b = appendField(b, start, "#type", d.Type, false)
+	b = appendField(b, start, "#time", d.Time, false)
+	b = appendField(b, start, "#event_name", d.EventName, true)
+	b = appendField(b, start, "#event_id", d.EventId, true)
+	b = appendField(b, start, "#first_check_id", d.FirstCheckId, true)
+	b = appendField(b, start, "#ip", d.Ip, true)
+	b = appendField(b, start, "#uuid", d.UUID, true)
+	b = appendField(b, start, "#app_id", d.AppId, true)
+	b = appendField(b, start, "#transaction_property", d.TransactionProperty, true)
+	b = appendField(b, start, "#import_tool_id", d.ImportToolId, true)
+	if len(b) > start {
+		b = append(b, ',')
+	}
+	b = append(b, `"properties":`...)
+	b, err := appendValue(b, d.Properties, 0)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '}'), nil
+}
+
+// appendField append a field of Data, start is the position after '{' of the object.
+func appendField(b []byte, start int, key, value string, omitEmpty bool) []byte {
+	if omitEmpty && len(value) == 0 {
+		return b
+	}
+	if len(b) > start {
+		b = append(b, ',')
+	}
+	b = appendString(b, key)
+	b = append(b, ':')
+	return appendString(b, value)
+}
+
+// appendValue encode the common types of properties directly, the others by reflection.
+// depth is the nesting level of the value, a value nested too deeply is taken as a cycle.
+func appendValue(b []byte, v interface{}, depth int) ([]byte, error) {
+	if depth > maxEncodeDepth {
+		return nil, errEncodeCycle(reflect.TypeOf(v))
+	}
+	switch value := v.(type) {
+	case nil:
+		return append(b, "null"...), nil
+	case string:
+		return appendString(b, value), nil
+	case bool:
+		return strconv.AppendBool(b, value), nil
+	case int:
+		return strconv.AppendInt(b, int64(value), 10), nil
+	case int32:
+		return strconv.AppendInt(b, int64(value), 10), nil
+	case int64:
+		return strconv.AppendInt(b, value, 10), nil
+	case uint:
+		return strconv.AppendUint(b, uint64(value), 10), nil
+	case uint64:
+		return strconv.AppendUint(b, value, 10), nil
+	case float64:
+		return appendFloat(b, value, 64)
+	case float32:
+		return appendFloat(b, float64(value), 32)
+	case json.Number:
+		if len(value) == 0 {
+			return append(b, '0'), nil
+		}
+		return append(b, value...), nil
+	case time.Time:
+		return appendString(b, value.Format(DATE_FORMAT)), nil
+	case map[string]interface{}:
+		if value == nil {
+			return append(b, "null"...), nil
+		}
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = append(b, '{')
+		var err error
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendString(b, k)
+			b = append(b, ':')
+			if b, err = appendValue(b, value[k], depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, '}'), nil
+	case []interface{}:
+		if value == nil {
+			return append(b, "null"...), nil
+		}
+		b = append(b, '[')
+		var err error
+		for i, item := range value {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			if b, err = appendValue(b, item, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, ']'), nil
+	case []string:
+		if value == nil {
+			return append(b, "null"...), nil
+		}
+		b = append(b, '[')
+		for i, item := range value {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendString(b, item)
+		}
+		return append(b, ']'), nil
+	default:
+		return appendReflect(b, reflect.ValueOf(v), depth)
+	}
+}
+
+// maxEncodeDepth the max nesting level of properties, a deeper value is most likely a cycle,
+// which encoding/json detects after the same level.
+const maxEncodeDepth = 1000
+
+func errEncodeCycle(t reflect.Type) error {
+	return fmt.Errorf("json: unsupported value: encountered a cycle via %v", t)
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func appendReflect(b []byte, rv reflect.Value, depth int) ([]byte, error) {
+	// the methods of pointer receiver are still found after dereference, as the element is addressable
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return append(b, "null"...), nil
+		}
+		if depth > maxEncodeDepth {
+			return nil, errEncodeCycle(rv.Type())
+		}
+		rv = rv.Elem()
+		depth++
+	}
+	if depth > maxEncodeDepth {
+		return nil, errEncodeCycle(rv.Type())
+	}
+	if !rv.IsValid() {
+		return append(b, "null"...), nil
+	}
+	if rv.Type() == timeType && rv.CanInterface() {
+		return appendString(b, rv.Interface().(time.Time).Format(DATE_FORMAT)), nil
+	}
+	if marshaler, ok := marshalerOf(rv); ok {
+		return appendMarshaler(b, marshaler)
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return strconv.AppendBool(b, rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(b, rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.AppendUint(b, rv.Uint(), 10), nil
+	case reflect.Float32:
+		return appendFloat(b, rv.Float(), 32)
+	case reflect.Float64:
+		return appendFloat(b, rv.Float(), 64)
+	case reflect.String:
+		if rv.Type() == reflect.TypeOf(json.Number("")) {
+			return appendValue(b, json.Number(rv.String()), depth)
+		}
+		return appendString(b, rv.String()), nil
+	case reflect.Map:
+		return appendMap(b, rv, depth)
+	case reflect.Slice:
+		if rv.IsNil() {
+			return append(b, "null"...), nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded as base64 string, like encoding/json
+			b = append(b, '"')
+			encoded := make([]byte, base64.StdEncoding.EncodedLen(rv.Len()))
+			base64.StdEncoding.Encode(encoded, rv.Bytes())
+			b = append(b, encoded...)
+			return append(b, '"'), nil
+		}
+		return appendList(b, rv, depth)
+	case reflect.Array:
+		return appendList(b, rv, depth)
+	case reflect.Struct:
+		return appendStruct(b, rv, depth)
+	default:
+		return nil, fmt.Errorf("json: unsupported type: %s", rv.Type())
+	}
+}
+
+// marshalerOf get json.Marshaler or encoding.TextMarshaler of the value, including the methods of pointer receiver.
+func marshalerOf(rv reflect.Value) (interface{}, bool) {
+	if !rv.CanInterface() {
+		return nil, false
+	}
+	t := rv.Type()
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return rv.Interface(), true
+	}
+	if rv.CanAddr() {
+		pt := reflect.PtrTo(t)
+		if pt.Implements(jsonMarshalerType) || pt.Implements(textMarshalerType) {
+			return rv.Addr().Interface(), true
+		}
+	}
+	return nil, false
+}
+
+func appendMarshaler(b []byte, marshaler interface{}) ([]byte, error) {
+	if m, ok := marshaler.(json.Marshaler); ok {
+		raw, err := m.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, raw); err != nil {
+			return nil, err
+		}
+		return append(b, buf.Bytes()...), nil
+	}
+	text, err := marshaler.(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return appendString(b, string(text)), nil
+}
+
+func appendMap(b []byte, rv reflect.Value, depth int) ([]byte, error) {
+	if rv.IsNil() {
+		return append(b, "null"...), nil
+	}
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		k := iter.Key()
+		var key string
+		switch k.Kind() {
+		case reflect.String:
+			key = k.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			key = strconv.FormatInt(k.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			key = strconv.FormatUint(k.Uint(), 10)
+		default:
+			return nil, fmt.Errorf("json: unsupported map key type: %s", k.Type())
+		}
+		entries = append(entries, entry{key: key, value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	b = append(b, '{')
+	var err error
+	for i, e := range entries {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendString(b, e.key)
+		b = append(b, ':')
+		if b, err = appendReflect(b, e.value, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return append(b, '}'), nil
+}
+
+func appendList(b []byte, rv reflect.Value, depth int) ([]byte, error) {
+	b = append(b, '[')
+	var err error
+	for i := 0; i < rv.Len(); i++ {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		if b, err = appendReflect(b, rv.Index(i), depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return append(b, ']'), nil
+}
+
+func appendStruct(b []byte, rv reflect.Value, depth int) ([]byte, error) {
+	b = append(b, '{')
+	first := true
+	for _, f := range cachedFields(rv.Type(), "json") {
+		fv, ok := fieldByIndex(rv, f.index)
+		if !ok || f.omitEmpty && fv.Kind() != reflect.Struct && isEmptyValue(fv) {
+			continue
+		}
+		if !first {
+			b = append(b, ',')
+		}
+		first = false
+		b = appendString(b, f.name)
+		b = append(b, ':')
+
+		var err error
+		if f.quoted && isQuotable(fv) {
+			var raw []byte
+			if raw, err = appendReflect(nil, fv, depth+1); err == nil {
+				b = appendString(b, string(raw))
+			}
+		} else {
+			b, err = appendReflect(b, fv, depth+1)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(b, '}'), nil
+}
+
+// fieldByIndex get the field of the index path, false if an embedded pointer on the way is nil.
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+func isQuotable(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+type taggedField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	quoted    bool // the "string" option
+	tagged    bool
+	depth     int
+}
+
+type fieldCacheKey struct {
+	t   reflect.Type
+	tag string
+}
+
+var fieldCache sync.Map // fieldCacheKey -> []taggedField
+
+// cachedFields get the fields of a struct named by the struct tag, following the rules of encoding/json
+// for the tags and embedded structs, e.g. cachedFields(t, "json") are the fields encoded by encoding/json.
+func cachedFields(t reflect.Type, tagName string) []taggedField {
+	key := fieldCacheKey{t: t, tag: tagName}
+	if fields, ok := fieldCache.Load(key); ok {
+		return fields.([]taggedField)
+	}
+	var fields []taggedField
+	collectFields(t, tagName, nil, 0, map[reflect.Type]bool{t: true}, &fields)
+
+	// the shallowest field wins, and a tagged field wins among the fields of the same depth
+	byName := make(map[string][]taggedField)
+	var names []string
+	for _, f := range fields {
+		if _, ok := byName[f.name]; !ok {
+			names = append(names, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+	result := make([]taggedField, 0, len(names))
+	for _, name := range names {
+		if f, ok := dominantField(byName[name]); ok {
+			result = append(result, f)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return lessIndex(result[i].index, result[j].index)
+	})
+
+	fieldCache.Store(key, result)
+	return result
+}
+
+// collectFields collect the fields of t and the embedded structs, visited holds the embedded types on the path.
+func collectFields(t reflect.Type, tagName string, index []int, depth int, visited map[reflect.Type]bool, fields *[]taggedField) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get(tagName)
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && len(name) == 0 && ft.Kind() == reflect.Struct && ft != timeType {
+			// promote the fields of embedded struct, even if the struct type is unexported
+			if !visited[ft] {
+				visited[ft] = true
+				collectFields(ft, tagName, fieldIndex, depth+1, visited, fields)
+				delete(visited, ft)
+			}
+			continue
+		}
+		if len(sf.PkgPath) > 0 {
+			// unexported field
+			continue
+		}
+
+		f := taggedField{name: name, index: fieldIndex, tagged: len(name) > 0, depth: depth}
+		if len(f.name) == 0 {
+			f.name = sf.Name
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "omitempty":
+				f.omitEmpty = true
+			case "string":
+				f.quoted = true
+			}
+		}
+		*fields = append(*fields, f)
+	}
+}
+
+func dominantField(fields []taggedField) (taggedField, bool) {
+	minDepth := fields[0].depth
+	for _, f := range fields {
+		if f.depth < minDepth {
+			minDepth = f.depth
+		}
+	}
+	var candidates, tagged []taggedField
+	for _, f := range fields {
+		if f.depth == minDepth {
+			candidates = append(candidates, f)
+			if f.tagged {
+				tagged = append(tagged, f)
+			}
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return taggedField{}, false
+}
+
+func lessIndex(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// appendFloat format the float like encoding/json
+func appendFloat(b []byte, f float64, bits int) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("json: unsupported value: %s", strconv.FormatFloat(f, 'g', -1, bits))
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b, nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendString quote the string like encoding/json, including the escape of HTML characters.
+func appendString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
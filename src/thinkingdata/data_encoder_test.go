@@ -0,0 +1,342 @@
+package thinkingdata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type encoderInner struct {
+	Name  string
+	Count int `json:"count"`
+	Inner string
+}
+
+type encoderOuter struct {
+	encoderInner
+	Name string // shadows encoderInner.Name
+	Note string `json:"note,omitempty"`
+}
+
+type encoderLeft struct {
+	Same string
+	Tag  string
+}
+
+type encoderRight struct {
+	Same string
+	Tag  string `json:"Tag"`
+}
+
+type encoderAmbiguous struct {
+	encoderLeft
+	encoderRight // Same is dropped, the tagged Tag wins
+	Own          int
+}
+
+type encoderPointerEmbed struct {
+	*encoderInner
+	Own int
+}
+
+type encoderOmit struct {
+	Str    string                 `json:"str,omitempty"`
+	Int    int                    `json:"int,omitempty"`
+	Float  float64                `json:"float,omitempty"`
+	Bool   bool                   `json:"bool,omitempty"`
+	Slice  []int                  `json:"slice,omitempty"`
+	Map    map[string]interface{} `json:"map,omitempty"`
+	Ptr    *int                   `json:"ptr,omitempty"`
+	Struct encoderInner           `json:"struct,omitempty"`
+	Quoted int                    `json:"quoted,string"`
+	Skip   string                 `json:"-"`
+	Dash   string                 `json:"-,"`
+	hidden string
+}
+
+type encoderMarshaler struct {
+	Value string
+}
+
+func (m encoderMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`{ "wrapped" : "` + m.Value + `" }`), nil
+}
+
+type encoderPtrMarshaler struct {
+	Value int
+}
+
+func (m *encoderPtrMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("[%d]", m.Value)), nil
+}
+
+type encoderText int
+
+func (t encoderText) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("text-%d<&>", int(t))), nil
+}
+
+type encoderFailing struct{}
+
+func (encoderFailing) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("failing marshaler")
+}
+
+type encoderNode struct {
+	Name string
+	Next *encoderNode
+}
+
+// TestMarshalDataCompatible the encoder writes the values without times exactly like encoding/json
+func TestMarshalDataCompatible(t *testing.T) {
+	count := 3
+	addressable := &struct{ Value encoderPtrMarshaler }{Value: encoderPtrMarshaler{Value: 7}}
+	cases := []struct {
+		name  string
+		value interface{}
+	}{
+		{"nil", nil},
+		{"string map", map[string]interface{}{"b": 1, "a": "x", "c": []interface{}{true, nil, 1.5}}},
+		{"nested map", map[string]interface{}{"m": map[string]interface{}{"z": 1, "y": map[string]int{"b": 2, "a": 1}}}},
+		{"int key map", map[int]string{10: "ten", 2: "two", -1: "minus"}},
+		{"uint key map", map[uint8]bool{3: true, 1: false}},
+		{"nil map", map[string]int(nil)},
+		{"empty map", map[string]int{}},
+		{"string slice", []string{"a", "b"}},
+		{"nil slice", []int(nil)},
+		{"array", [3]int{1, 2, 3}},
+		{"bytes", []byte("hello")},
+		{"numbers", []interface{}{int8(-1), int16(2), int32(3), int64(4), uint(5), uint16(6), uint32(7), uint64(8), float32(1.1), 2.5, json.Number("12.50")}},
+		{"floats", []float64{0, -0.5, 1e20, 1e21, 1e-6, 1e-7, 123456789.125, math.MaxFloat64, math.SmallestNonzeroFloat64}},
+		{"float32", []float32{1e20, 1e21, 1e-7, 3.4028235e38, 0.1}},
+		{"embedded", encoderOuter{encoderInner: encoderInner{Name: "inner", Count: 1, Inner: "i"}, Name: "outer"}},
+		{"ambiguous", encoderAmbiguous{encoderLeft{Same: "l", Tag: "l"}, encoderRight{Same: "r", Tag: "r"}, 1}},
+		{"nil embedded pointer", encoderPointerEmbed{Own: 1}},
+		{"embedded pointer", encoderPointerEmbed{encoderInner: &encoderInner{Name: "p"}, Own: 1}},
+		{"omitempty zero", encoderOmit{}},
+		{"omitempty set", encoderOmit{Str: "s", Int: 1, Float: 1.5, Bool: true, Slice: []int{1}, Map: map[string]interface{}{"k": "v"}, Ptr: &count, Quoted: 9, Skip: "skip", Dash: "dash", hidden: "hidden"}},
+		{"omitempty empty containers", encoderOmit{Slice: []int{}, Map: map[string]interface{}{}}},
+		{"json marshaler", encoderMarshaler{Value: "v"}},
+		{"json marshaler in slice", []encoderMarshaler{{Value: "a"}, {Value: "b"}}},
+		{"pointer receiver marshaler", &encoderPtrMarshaler{Value: 3}},
+		{"addressable pointer receiver marshaler", addressable},
+		{"pointer receiver marshaler in map", map[string]encoderPtrMarshaler{"a": {Value: 1}}},
+		{"text marshaler", encoderText(5)},
+		{"text marshaler in map", map[string]interface{}{"t": encoderText(6)}},
+		{"html", "<script>alert('a&b')</script>"},
+		{"escapes", "quote\" backslash\\ newline\n tab\t cr\r bell\a nul\x00     中文"},
+		{"invalid utf8", "bad\xff\xfebytes"},
+		{"html keys", map[string]string{"<key>": "&value"}},
+		{"pointer", &count},
+		{"nil pointer", (*int)(nil)},
+		{"list of structs", []encoderNode{{Name: "a"}, {Name: "b", Next: &encoderNode{Name: "c"}}}},
+		{"anonymous struct", struct {
+			A int    `json:"a"`
+			B string `json:"b,omitempty"`
+		}{A: 1}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			expected, err := json.Marshal(c.value)
+			if err != nil {
+				t.Fatalf("encoding/json: %v", err)
+			}
+			actual, err := appendValue(nil, c.value, 0)
+			if err != nil {
+				t.Fatalf("MarshalData: %v", err)
+			}
+			if string(actual) != string(expected) {
+				t.Errorf("got %s, want %s", actual, expected)
+			}
+		})
+	}
+}
+
+func TestMarshalDataFields(t *testing.T) {
+	d := Data{
+		IsComplex:  true,
+		AccountId:  "account",
+		Type:       Track,
+		Time:       "2023-01-02 03:04:05.678",
+		EventName:  "<event>",
+		UUID:       "uuid",
+		Properties: map[string]interface{}{"a": 1, "b": []interface{}{"x"}},
+	}
+	for _, v := range []Data{d, {}} {
+		expected, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := MarshalData(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != string(expected) {
+			t.Errorf("got %s, want %s", actual, expected)
+		}
+	}
+
+	list, err := marshalDataList([]Data{d, d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := json.Marshal([]Data{d, d})
+	if string(list) != string(expected) {
+		t.Errorf("got %s, want %s", list, expected)
+	}
+}
+
+func TestMarshalDataTime(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	now := time.Date(2023, 1, 2, 3, 4, 5, 678901234, loc)
+	formatted := `"2023-01-02 03:04:05.678"`
+	cases := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"time", now, formatted},
+		{"pointer", &now, formatted},
+		{"nested map", map[string]interface{}{"m": map[string]interface{}{"t": now}}, `{"m":{"t":` + formatted + `}}`},
+		{"typed map", map[string]time.Time{"t": now}, `{"t":` + formatted + `}`},
+		{"slice", []interface{}{now, 1}, `[` + formatted + `,1]`},
+		{"typed slice", []time.Time{now}, `[` + formatted + `]`},
+		{"struct", struct {
+			At  time.Time
+			Ptr *time.Time `json:"ptr,omitempty"`
+		}{At: now}, `{"At":` + formatted + `}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := appendValue(nil, c.value, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(actual) != c.expected {
+				t.Errorf("got %s, want %s", actual, c.expected)
+			}
+		})
+	}
+}
+
+func TestMarshalDataErrors(t *testing.T) {
+	node := &encoderNode{Name: "loop"}
+	node.Next = node
+	cyclicMap := map[string]interface{}{}
+	cyclicMap["self"] = cyclicMap
+	cyclicList := []interface{}{nil}
+	cyclicList[0] = cyclicList
+
+	cases := []struct {
+		name  string
+		value interface{}
+	}{
+		{"NaN", math.NaN()},
+		{"+Inf", math.Inf(1)},
+		{"-Inf float32", float32(math.Inf(-1))},
+		{"NaN in map", map[string]interface{}{"v": math.NaN()}},
+		{"Inf in struct", struct{ V float64 }{V: math.Inf(1)}},
+		{"channel", make(chan int)},
+		{"func", func() {}},
+		{"failing marshaler", encoderFailing{}},
+		{"cyclic pointer", node},
+		{"cyclic map", cyclicMap},
+		{"cyclic slice", cyclicList},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := json.Marshal(c.value); err == nil {
+				t.Fatal("encoding/json: expected an error")
+			}
+			if _, err := MarshalData(Data{Properties: map[string]interface{}{"v": c.value}}); err == nil {
+				t.Error("MarshalData: expected an error")
+			}
+		})
+	}
+
+	// encoding/json overflows the stack on this one
+	var cyclicInterface interface{}
+	cyclicInterface = &cyclicInterface
+	if _, err := MarshalData(Data{Properties: map[string]interface{}{"v": cyclicInterface}}); err == nil {
+		t.Error("MarshalData: expected an error of cyclic interface")
+	}
+}
+
+var encoderTimeRegexp = regexp.MustCompile(`"((\d{4}-\d{2}-\d{2})T(\d{2}:\d{2}:\d{2})(?:\.(\d{3}))\d*)(Z|[\+-]\d{2}:\d{2})"`)
+
+// regexpMarshal the way of encoding before the encoder, encoding/json with the times rewritten by a regular expression
+func regexpMarshal(d Data) ([]byte, error) {
+	input, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	for encoderTimeRegexp.Match(input) {
+		input = encoderTimeRegexp.ReplaceAll(input, []byte(`"$2 $3.$4"`))
+	}
+	return input, nil
+}
+
+func newBenchmarkData(nested int) Data {
+	now := time.Now()
+	properties := map[string]interface{}{
+		"name":  "purchase",
+		"price": 9.9,
+		"count": 3,
+		"time":  now,
+	}
+	for i := 0; i < nested; i++ {
+		properties[fmt.Sprintf("object_%d", i)] = map[string]interface{}{
+			"time":  now,
+			"items": []interface{}{1, "item", now},
+		}
+	}
+	return Data{
+		AccountId:  "account",
+		Type:       Track,
+		Time:       now.Format(DATE_FORMAT),
+		EventName:  "purchase",
+		UUID:       "2ef3ef11-c9d6-11f1-8b6f-d2b0a3c4b3b4",
+		Properties: properties,
+	}
+}
+
+func BenchmarkMarshalData(b *testing.B) {
+	for _, nested := range []int{0, 10, 50} {
+		d := newBenchmarkData(nested)
+		for _, encoder := range []struct {
+			name    string
+			marshal func(Data) ([]byte, error)
+		}{
+			{"encoder", MarshalData},
+			{"regexp", regexpMarshal},
+		} {
+			b.Run(fmt.Sprintf("%s/nested=%d", encoder.name, nested), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := encoder.marshal(d); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRegexpMarshalSameOutput(t *testing.T) {
+	d := newBenchmarkData(3)
+	expected, err := regexpMarshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := MarshalData(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != string(expected) {
+		t.Errorf("got %s, want %s", actual, expected)
+	}
+}
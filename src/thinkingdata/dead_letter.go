@@ -0,0 +1,318 @@
+package thinkingdata
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	deadLetterFilePrefix = "deadletter."
+	deadLetterFileSuffix = ".jsonl"
+)
+
+// properties added to the data by the sink of NewDeadLetterConsumer
+const (
+	DeadLetterPropertyTime       = "dead_letter_time"
+	DeadLetterPropertyStatusCode = "dead_letter_status_code"
+	DeadLetterPropertyCode       = "dead_letter_code"
+	DeadLetterPropertyResponse   = "dead_letter_response"
+)
+
+// TDDeadLetter a data rejected by the receiver, with the response of the receiver
+type TDDeadLetter struct {
+	Time       string // when the data is rejected, in DATE_FORMAT
+	AppId      string // appId of the request
+	StatusCode int    // http status code
+	Code       int    // code in the response of receiver
+	Response   string // response content
+	Error      string
+	Data       Data
+}
+
+// TDDeadLetterSink receive the data rejected by the receiver, see TDBatchConfig.DeadLetter.
+// WriteDeadLetters is called after the locks of the consumer are released, like the callbacks.
+type TDDeadLetterSink interface {
+	WriteDeadLetters(letters []TDDeadLetter) error
+}
+
+// newDeadLetters one letter for each data of a batch rejected with err
+func newDeadLetters(batch *eventBatch, err error) []TDDeadLetter {
+	template := TDDeadLetter{
+		Time:  time.Now().Format(DATE_FORMAT),
+		AppId: batch.appId,
+		Error: err.Error(),
+	}
+	var receiverErr *ReceiverError
+	if errors.As(err, &receiverErr) {
+		template.StatusCode = receiverErr.StatusCode
+		template.Code = receiverErr.Code
+		template.Response = receiverErr.Body
+		template.Error = receiverErr.Err.Error()
+	}
+	letters := make([]TDDeadLetter, len(batch.events))
+	for i, d := range batch.events {
+		letters[i] = template
+		letters[i].Data = d
+	}
+	return letters
+}
+
+// deadLetterLine a line of the dead-letter files
+type deadLetterLine struct {
+	Time       string          `json:"time"`
+	AppId      string          `json:"app_id,omitempty"`
+	StatusCode int             `json:"status_code"`
+	Code       int             `json:"code"`
+	Response   string          `json:"response,omitempty"`
+	Error      string          `json:"error"`
+	Data       json.RawMessage `json:"data"`
+}
+
+func marshalDeadLetter(l TDDeadLetter) ([]byte, error) {
+	data, err := MarshalData(l.Data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(deadLetterLine{
+		Time:       l.Time,
+		AppId:      l.AppId,
+		StatusCode: l.StatusCode,
+		Code:       l.Code,
+		Response:   l.Response,
+		Error:      l.Error,
+		Data:       data,
+	})
+}
+
+func unmarshalDeadLetter(content []byte) (TDDeadLetter, error) {
+	var line deadLetterLine
+	if err := json.Unmarshal(content, &line); err != nil {
+		return TDDeadLetter{}, err
+	}
+	if len(line.Data) == 0 {
+		return TDDeadLetter{}, fmt.Errorf("%w: data not be empty", ErrInvalidParams)
+	}
+	d, err := decodeData(line.Data)
+	if err != nil {
+		return TDDeadLetter{}, err
+	}
+	return TDDeadLetter{
+		Time:       line.Time,
+		AppId:      line.AppId,
+		StatusCode: line.StatusCode,
+		Code:       line.Code,
+		Response:   line.Response,
+		Error:      line.Error,
+		Data:       d,
+	}, nil
+}
+
+// TDDeadLetterDir write the dead letters to a directory, one JSON object per line in a file per day.
+// The data is in the "data" field of the lines, it can be fixed in place and uploaded by ResubmitDeadLetters.
+type TDDeadLetterDir struct {
+	directory string
+	mutex     *sync.Mutex
+}
+
+func NewDeadLetterDir(directory string) (*TDDeadLetterDir, error) {
+	if len(directory) == 0 {
+		return nil, fmt.Errorf("%w: directory not be empty", ErrInvalidParams)
+	}
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &TDDeadLetterDir{directory: directory, mutex: new(sync.Mutex)}, nil
+}
+
+func (s *TDDeadLetterDir) WriteDeadLetters(letters []TDDeadLetter) error {
+	var buf bytes.Buffer
+	for _, l := range letters {
+		line, err := marshalDeadLetter(l)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name := filepath.Join(s.directory, deadLetterFilePrefix+time.Now().Format("2006-01-02")+deadLetterFileSuffix)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Files list the dead-letter files in the directory, the oldest first.
+func (s *TDDeadLetterDir) Files() ([]string, error) {
+	return ListDeadLetterFiles(s.directory)
+}
+
+// ListDeadLetterFiles list the files written by TDDeadLetterDir in the directory, the oldest first.
+func ListDeadLetterFiles(directory string) ([]string, error) {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), deadLetterFilePrefix) || !strings.HasSuffix(f.Name(), deadLetterFileSuffix) {
+			continue
+		}
+		names = append(names, filepath.Join(directory, f.Name()))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ReadDeadLetterFile read a file written by TDDeadLetterDir.
+func ReadDeadLetterFile(name string) ([]TDDeadLetter, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var letters []TDDeadLetter
+	reader := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			l, decodeErr := unmarshalDeadLetter(line)
+			if decodeErr != nil {
+				return nil, fmt.Errorf("%s:%d: %w", name, lineNo, decodeErr)
+			}
+			letters = append(letters, l)
+		}
+		if err != nil {
+			if err == io.EOF {
+				return letters, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// writeDeadLetterFile replace the content of a dead-letter file, the file is removed when there is no letter.
+func writeDeadLetterFile(name string, letters []TDDeadLetter) error {
+	if len(letters) == 0 {
+		return os.Remove(name)
+	}
+	var buf bytes.Buffer
+	for _, l := range letters {
+		line, err := marshalDeadLetter(l)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := ioutil.WriteFile(name+spoolTempSuffix, buf.Bytes(), 0664); err != nil {
+		return err
+	}
+	return os.Rename(name+spoolTempSuffix, name)
+}
+
+type deadLetterConsumer struct {
+	consumer TDConsumer
+}
+
+// NewDeadLetterConsumer a sink which adds the dead letters to a consumer, e.g. a TDLogConsumer.
+// The response of the receiver is added to the properties, see DeadLetterPropertyTime and the like.
+// The consumer must not be the TDBatchConsumer which the sink is set to.
+func NewDeadLetterConsumer(consumer TDConsumer) TDDeadLetterSink {
+	return &deadLetterConsumer{consumer: consumer}
+}
+
+func (s *deadLetterConsumer) WriteDeadLetters(letters []TDDeadLetter) error {
+	for _, l := range letters {
+		d := l.Data
+		d.Properties = make(map[string]interface{}, len(l.Data.Properties)+4)
+		mergeProperties(d.Properties, l.Data.Properties)
+		d.Properties[DeadLetterPropertyTime] = l.Time
+		d.Properties[DeadLetterPropertyStatusCode] = l.StatusCode
+		d.Properties[DeadLetterPropertyCode] = l.Code
+		d.Properties[DeadLetterPropertyResponse] = l.Response
+		if err := s.consumer.Add(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDeadLetters send a batch rejected by the receiver to the dead-letter sink,
+// the letters are written by runCallbacks after the locks are released.
+func (c *TDBatchConsumer) writeDeadLetters(batch *eventBatch, err error) {
+	if c.deadLetter == nil {
+		return
+	}
+	letters := newDeadLetters(batch, err)
+	logger := c.logger.with(logAttr(LogKeyAppId, batch.appId), logAttr(LogKeyBatchSize, len(batch.events)))
+	c.notify(func() {
+		if writeErr := c.deadLetter.WriteDeadLetters(letters); writeErr != nil {
+			logger.with(logAttr(LogKeyError, writeErr)).error("write dead letters failed: %s", writeErr)
+		}
+	})
+}
+
+// ResubmitDeadLetters upload the data of a file written by TDDeadLetterDir again, e.g. after fixing it.
+// The data rejected again goes to the dead-letter sink of the consumer, which must be set and must not write to the same directory.
+// The file is removed when all the data has reached a final state. If the upload stops on an error,
+// the file is rewritten with the data which is not uploaded yet. It returns the count of the data which has reached a final state.
+func (c *TDBatchConsumer) ResubmitDeadLetters(ctx context.Context, name string) (int, error) {
+	if c.deadLetter == nil {
+		// the data rejected again would be lost with the file
+		return 0, fmt.Errorf("%w: no dead-letter sink is set to the consumer", ErrInvalidParams)
+	}
+	if dir, ok := c.deadLetter.(*TDDeadLetterDir); ok {
+		if sameDir, _ := filepath.Abs(dir.directory); sameDir == absDir(name) {
+			return 0, fmt.Errorf("%w: the dead letters of the consumer are written to the same directory", ErrInvalidParams)
+		}
+	}
+
+	letters, err := ReadDeadLetterFile(name)
+	if err != nil {
+		return 0, err
+	}
+	events := make([]Data, len(letters))
+	for i, l := range letters {
+		events[i] = l.Data
+		if len(events[i].AppId) == 0 {
+			// back to the app which rejected it
+			events[i].AppId = l.AppId
+		}
+	}
+
+	sent := 0
+	err = c.uploadEvents(ctx, events, func(n int) { sent = n })
+	if rewriteErr := writeDeadLetterFile(name, letters[sent:]); rewriteErr != nil {
+		c.logger.with(logAttr(LogKeyFile, name), logAttr(LogKeyError, rewriteErr)).error("rewrite dead-letter file failed: %s", rewriteErr)
+		if err == nil {
+			err = rewriteErr
+		}
+	}
+	return sent, err
+}
+
+func absDir(name string) string {
+	dir, _ := filepath.Abs(filepath.Dir(name))
+	return dir
+}
@@ -0,0 +1,149 @@
+package thinkingdata_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata/thinkingdatatest"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	directory, err := ioutil.TempDir("", "thinkingdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+	return directory
+}
+
+// writeDeadLetterFile write the letters to a dead-letter directory, and return the file.
+func writeDeadLetterFile(t *testing.T, directory string, events ...string) string {
+	t.Helper()
+	dir, err := thinkingdata.NewDeadLetterDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	letters := make([]thinkingdata.TDDeadLetter, len(events))
+	for i, eventName := range events {
+		letters[i] = thinkingdata.TDDeadLetter{
+			AppId: "app",
+			Code:  -1,
+			Error: "invalid data format",
+			Data: thinkingdata.Data{
+				AccountId: "account",
+				Type:      thinkingdata.Track,
+				EventName: eventName,
+				Time:      "2023-01-02 03:04:05.000",
+			},
+		}
+	}
+	if err := dir.WriteDeadLetters(letters); err != nil {
+		t.Fatal(err)
+	}
+	files, err := dir.Files()
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got files %v, %v", files, err)
+	}
+	return files[0]
+}
+
+func newResubmitConsumer(t *testing.T, r *thinkingdatatest.Receiver, deadLetterDir string) *thinkingdata.TDBatchConsumer {
+	t.Helper()
+	c, err := thinkingdata.NewBatchConsumerWithConfig(thinkingdata.TDBatchConfig{
+		ServerUrl:     r.URL,
+		AppId:         "app",
+		Compress:      true,
+		DeadLetterDir: deadLetterDir,
+		RetryPolicy:   &thinkingdata.TDRetryPolicy{MaxAttempts: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c.(*thinkingdata.TDBatchConsumer)
+}
+
+func TestResubmitDeadLettersWithoutSink(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.SetCode(-1)
+	name := writeDeadLetterFile(t, tempDir(t), "a", "b")
+	before, _ := ioutil.ReadFile(name)
+
+	c := newResubmitConsumer(t, r, "")
+	count, err := c.ResubmitDeadLetters(context.Background(), name)
+	if !errors.Is(err, thinkingdata.ErrInvalidParams) || count != 0 {
+		t.Fatalf("got %d, %v, want ErrInvalidParams", count, err)
+	}
+	if len(r.Requests()) != 0 {
+		t.Errorf("got %d requests, want none", len(r.Requests()))
+	}
+	if after, _ := ioutil.ReadFile(name); string(after) != string(before) {
+		t.Error("the dead-letter file is changed")
+	}
+}
+
+func TestResubmitDeadLetters(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	name := writeDeadLetterFile(t, tempDir(t), "a", "b")
+
+	rejectedDir := tempDir(t)
+	c := newResubmitConsumer(t, r, rejectedDir)
+	count, err := c.ResubmitDeadLetters(context.Background(), name)
+	if err != nil || count != 2 {
+		t.Fatalf("got %d, %v, want 2", count, err)
+	}
+	if events := r.Events(); len(events) != 2 || events[0].EventName != "a" || events[1].EventName != "b" {
+		t.Errorf("got events %v", events)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("the dead-letter file is not removed: %v", err)
+	}
+}
+
+func TestResubmitDeadLettersRejectedAgain(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.SetCode(-1)
+	name := writeDeadLetterFile(t, tempDir(t), "a", "b")
+
+	rejectedDir := tempDir(t)
+	c := newResubmitConsumer(t, r, rejectedDir)
+	count, err := c.ResubmitDeadLetters(context.Background(), name)
+	if count != 2 {
+		t.Fatalf("got %d, %v, want 2", count, err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("the dead-letter file is not removed: %v", err)
+	}
+	// the data is kept by the sink of the consumer
+	files, _ := thinkingdata.ListDeadLetterFiles(rejectedDir)
+	if len(files) != 1 {
+		t.Fatalf("got rejected files %v", files)
+	}
+	letters, err := thinkingdata.ReadDeadLetterFile(files[0])
+	if err != nil || len(letters) != 2 || letters[0].Code != -1 {
+		t.Errorf("got letters %v, %v", letters, err)
+	}
+}
+
+func TestResubmitDeadLettersSameDir(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	directory := tempDir(t)
+	name := writeDeadLetterFile(t, directory, "a")
+
+	c := newResubmitConsumer(t, r, directory)
+	if _, err := c.ResubmitDeadLetters(context.Background(), name); !errors.Is(err, thinkingdata.ErrInvalidParams) {
+		t.Fatalf("got %v, want ErrInvalidParams", err)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Error(err)
+	}
+}
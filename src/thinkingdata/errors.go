@@ -0,0 +1,106 @@
+package thinkingdata
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors of SDK, check them with errors.Is
+var (
+	ErrEmptyServerUrl       = errors.New("ServerUrl not be empty")
+	ErrUnknownRotateMode    = errors.New("unknown rotate mode")
+	ErrConsumerClosed       = errors.New("SDK has been closed")
+	ErrEmptyUserId          = errors.New("invalid parameters: account_id and distinct_id cannot be empty at the same time")
+	ErrEmptyEventName       = errors.New("the event name must be provided")
+	ErrEmptyEventId         = errors.New("the event id must be provided")
+	ErrEmptyFirstCheckId    = errors.New("the 'firstCheckId' must be provided")
+	ErrInvalidParams        = errors.New("invalid params")
+	ErrInvalidEventName     = errors.New("invalid event name")
+	ErrInvalidPropertyKey   = errors.New("invalid property key")
+	ErrInvalidPropertyValue = errors.New("invalid property value")
+	ErrEventDropped         = errors.New("event dropped")
+	ErrRateLimited          = errors.New("rate limit exceeded")
+
+	// errors answered by the receiver
+	ErrInvalidDataFormat   = errors.New("invalid data format")
+	ErrAppIdNotExist       = errors.New("APP ID doesn't exist")
+	ErrInvalidIp           = errors.New("invalid ip transmission")
+	ErrUnknownReceiverCode = errors.New("unknown error")
+	ErrUnexpectedStatus    = errors.New("unexpected status code")
+)
+
+// ValidationError is returned when the data is rejected by the SDK before being sent.
+// It wraps one of ErrInvalidEventName, ErrInvalidPropertyKey and ErrInvalidPropertyValue.
+type ValidationError struct {
+	Key    string      // the offending key, "#event_name" for the event name
+	Value  interface{} // the offending value
+	Reason string      // detail of the failure
+	Err    error       // sentinel error
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Reason) == 0 {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Reason
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// DropError is returned when an interceptor drops the data, it wraps ErrEventDropped.
+type DropError struct {
+	Reason string // why the data is dropped
+}
+
+// NewDropError create a DropError, interceptors return it to drop the data.
+func NewDropError(reason string) error {
+	return &DropError{Reason: reason}
+}
+
+func (e *DropError) Error() string {
+	return ErrEventDropped.Error() + ": " + e.Reason
+}
+
+func (e *DropError) Unwrap() error {
+	return ErrEventDropped
+}
+
+// ReceiverError is returned when the receiver doesn't accept the data.
+// It wraps one of ErrInvalidDataFormat, ErrAppIdNotExist, ErrInvalidIp, ErrUnknownReceiverCode and ErrUnexpectedStatus.
+type ReceiverError struct {
+	StatusCode int    // http status code
+	Code       int    // code in the response of receiver, only valid when StatusCode is 200
+	BatchSize  int    // count of events in the request
+	AppId      string // appId of the request
+	Body       string // response content
+	Retryable  bool   // the data is kept by the consumer and will be sent again
+	Err        error  // sentinel error
+}
+
+func (e *ReceiverError) Error() string {
+	msg := fmt.Sprintf("%s (status: %d, code: %d, appId: %s, batch size: %d)", e.Err, e.StatusCode, e.Code, e.AppId, e.BatchSize)
+	if len(e.Body) > 0 {
+		msg += ", return content: " + e.Body
+	}
+	return msg
+}
+
+func (e *ReceiverError) Unwrap() error {
+	return e.Err
+}
+
+// receiverCodeError map the code in the response of /sync_server to a sentinel error
+func receiverCodeError(code int) error {
+	switch code {
+	case 1, -1:
+		return ErrInvalidDataFormat
+	case -2:
+		return ErrAppIdNotExist
+	case -3:
+		return ErrInvalidIp
+	default:
+		return ErrUnknownReceiverCode
+	}
+}
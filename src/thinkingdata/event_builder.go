@@ -0,0 +1,233 @@
+package thinkingdata
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventBuilder build an event with typed preset properties, e.g.
+//
+//	err := ta.Event("purchase").Account("A1").Time(t).IP("1.2.3.4").Props(p).Send()
+//
+// The first invalid value is kept and returned by Send, and the event is not reported.
+type EventBuilder struct {
+	ta         *TDAnalytics
+	accountId  string
+	distinctId string
+	dataType   string
+	eventName  string
+	eventId    string
+	properties map[string]interface{}
+
+	eventTime           time.Time
+	ip                  string
+	uuid                string
+	appId               string
+	firstCheckId        string
+	transactionProperty string
+	importToolId        string
+
+	err error
+}
+
+// Event start building an ordinary event
+func (ta *TDAnalytics) Event(eventName string) *EventBuilder {
+	return &EventBuilder{
+		ta:         ta,
+		dataType:   Track,
+		eventName:  eventName,
+		properties: make(map[string]interface{}),
+	}
+}
+
+// Account set the account id of the event
+func (b *EventBuilder) Account(accountId string) *EventBuilder {
+	b.accountId = accountId
+	return b
+}
+
+// Distinct set the distinct id of the event
+func (b *EventBuilder) Distinct(distinctId string) *EventBuilder {
+	b.distinctId = distinctId
+	return b
+}
+
+// Time set "#time" of the event, the current time is used if not set.
+func (b *EventBuilder) Time(t time.Time) *EventBuilder {
+	if t.IsZero() {
+		return b.invalid("#time", t, "zero time")
+	}
+	b.eventTime = t
+	return b
+}
+
+// IP set "#ip" of the event, it must be an IPv4 or IPv6 address.
+func (b *EventBuilder) IP(ip string) *EventBuilder {
+	if net.ParseIP(ip) == nil {
+		return b.invalid("#ip", ip, "invalid ip address: "+ip)
+	}
+	b.ip = ip
+	return b
+}
+
+// UUID set "#uuid" of the event, which is used to remove duplicated data. A random uuid is used if not set.
+func (b *EventBuilder) UUID(u string) *EventBuilder {
+	if _, err := uuid.Parse(u); err != nil {
+		return b.invalid("#uuid", u, "invalid uuid: "+u)
+	}
+	b.uuid = u
+	return b
+}
+
+// AppId set "#app_id" of the event, to report it to another project.
+func (b *EventBuilder) AppId(appId string) *EventBuilder {
+	if len(appId) == 0 {
+		return b.invalid("#app_id", appId, "empty app id")
+	}
+	b.appId = appId
+	return b
+}
+
+// First report the event as a first event, which is only recorded once for the firstCheckId.
+func (b *EventBuilder) First(firstCheckId string) *EventBuilder {
+	if len(firstCheckId) == 0 {
+		return b.fail(ErrEmptyFirstCheckId)
+	}
+	b.firstCheckId = firstCheckId
+	return b
+}
+
+// Update report the event as an updatable event
+func (b *EventBuilder) Update(eventId string) *EventBuilder {
+	b.dataType = TrackUpdate
+	b.eventId = eventId
+	return b
+}
+
+// Overwrite report the event as an overridable event
+func (b *EventBuilder) Overwrite(eventId string) *EventBuilder {
+	b.dataType = TrackOverwrite
+	b.eventId = eventId
+	return b
+}
+
+// TransactionProperty set "#transaction_property" of the event
+func (b *EventBuilder) TransactionProperty(v string) *EventBuilder {
+	b.transactionProperty = v
+	return b
+}
+
+// ImportToolId set "#import_tool_id" of the event
+func (b *EventBuilder) ImportToolId(v string) *EventBuilder {
+	b.importToolId = v
+	return b
+}
+
+// Prop set a custom property, a preset property of the wrong type, e.g. "#ip" of a number, is invalid.
+func (b *EventBuilder) Prop(key string, value interface{}) *EventBuilder {
+	if err := checkPresetProperty(key, value); err != nil {
+		return b.fail(err)
+	}
+	b.properties[key] = value
+	return b
+}
+
+// Props set custom properties, a preset property of the wrong type, e.g. "#ip" of a number, is invalid.
+func (b *EventBuilder) Props(properties map[string]interface{}) *EventBuilder {
+	for key, value := range properties {
+		if err := checkPresetProperty(key, value); err != nil {
+			return b.fail(err)
+		}
+	}
+	mergeProperties(b.properties, properties)
+	return b
+}
+
+// Send report the event
+func (b *EventBuilder) Send() error {
+	return b.SendCtx(context.Background())
+}
+
+// SendCtx report the event, the context is passed down to the consumer.
+func (b *EventBuilder) SendCtx(ctx context.Context) error {
+	defer func() {
+		if r := recover(); r != nil {
+			b.ta.log().error("%+v\ndata: %+v", r, b.properties)
+		}
+	}()
+
+	if b.err != nil {
+		return b.err
+	}
+	if err := b.ta.checkEvent(b.dataType, b.eventName, b.eventId); err != nil {
+		return err
+	}
+
+	p := b.ta.eventProperties(b.properties)
+	// the super properties are checked here
+	for key, value := range p {
+		if err := checkPresetProperty(key, value); err != nil {
+			return b.fail(err).err
+		}
+	}
+	// the typed values take precedence over the ones in properties
+	if !b.eventTime.IsZero() {
+		p["#time"] = b.eventTime
+	}
+	data := b.ta.newData(b.accountId, b.distinctId, b.dataType, b.eventName, b.eventId, p)
+
+	if len(b.ip) > 0 {
+		data.Ip = b.ip
+	}
+	if len(b.uuid) > 0 {
+		data.UUID = b.uuid
+	}
+	if len(b.appId) > 0 {
+		data.AppId = b.appId
+	}
+	if len(b.firstCheckId) > 0 {
+		data.FirstCheckId = b.firstCheckId
+	}
+	if len(b.transactionProperty) > 0 {
+		data.TransactionProperty = b.transactionProperty
+	}
+	if len(b.importToolId) > 0 {
+		data.ImportToolId = b.importToolId
+	}
+
+	return b.ta.dispatch(ctx, data)
+}
+
+// checkPresetProperty check the type of a preset property, which is moved out of the properties by newData.
+// newData only logs a warning and reports an empty value, the builder rejects it instead.
+func checkPresetProperty(key string, value interface{}) error {
+	switch key {
+	case "#ip", "#uuid", "#app_id", "#first_check_id", "#transaction_property", "#import_tool_id":
+		if _, ok := value.(string); !ok {
+			return &ValidationError{Key: key, Value: value, Reason: fmt.Sprintf("%s must be a string, got %T", key, value), Err: ErrInvalidPropertyValue}
+		}
+	case "#time":
+		switch value.(type) {
+		case string, time.Time:
+		default:
+			return &ValidationError{Key: key, Value: value, Reason: fmt.Sprintf("%s must be a time.Time or string, got %T", key, value), Err: ErrInvalidPropertyValue}
+		}
+	}
+	return nil
+}
+
+func (b *EventBuilder) invalid(key string, value interface{}, reason string) *EventBuilder {
+	return b.fail(&ValidationError{Key: key, Value: value, Reason: reason, Err: ErrInvalidPropertyValue})
+}
+
+func (b *EventBuilder) fail(err error) *EventBuilder {
+	if b.err == nil {
+		b.ta.log().error(err.Error())
+		b.err = err
+	}
+	return b
+}
@@ -0,0 +1,77 @@
+package thinkingdata_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata/thinkingdatatest"
+)
+
+func TestEventBuilder(t *testing.T) {
+	c := thinkingdatatest.NewRecordingConsumer()
+	ta := thinkingdata.New(c)
+	eventTime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.Local)
+
+	err := ta.Event("purchase").Account("A1").Time(eventTime).IP("1.2.3.4").
+		Props(map[string]interface{}{"price": 9.9, "#app_id": "other"}).Prop("count", 2).Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := thinkingdatatest.AssertEvent(t, c, "purchase")
+	thinkingdatatest.AssertUser(t, d, "A1", "")
+	thinkingdatatest.AssertProperties(t, d, map[string]interface{}{"price": 9.9, "count": 2})
+	if d.Ip != "1.2.3.4" || d.AppId != "other" || d.Time != "2023-01-02 03:04:05.000" {
+		t.Errorf("got ip %q, app id %q, time %q", d.Ip, d.AppId, d.Time)
+	}
+}
+
+func TestEventBuilderInvalidPreset(t *testing.T) {
+	cases := []struct {
+		name  string
+		build func(b *thinkingdata.EventBuilder) *thinkingdata.EventBuilder
+		key   string
+	}{
+		{"ip in Props", func(b *thinkingdata.EventBuilder) *thinkingdata.EventBuilder {
+			return b.Props(map[string]interface{}{"#ip": 123})
+		}, "#ip"},
+		{"uuid in Prop", func(b *thinkingdata.EventBuilder) *thinkingdata.EventBuilder {
+			return b.Prop("#uuid", []byte("uuid"))
+		}, "#uuid"},
+		{"time in Prop", func(b *thinkingdata.EventBuilder) *thinkingdata.EventBuilder {
+			return b.Prop("#time", 1672628645)
+		}, "#time"},
+		{"invalid ip", func(b *thinkingdata.EventBuilder) *thinkingdata.EventBuilder {
+			return b.IP("localhost")
+		}, "#ip"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := thinkingdatatest.NewRecordingConsumer()
+			ta := thinkingdata.New(c)
+			err := tc.build(ta.Event("purchase").Account("A1")).Send()
+			var validationErr *thinkingdata.ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Key != tc.key {
+				t.Fatalf("got %v, want a ValidationError of %s", err, tc.key)
+			}
+			if !errors.Is(err, thinkingdata.ErrInvalidPropertyValue) {
+				t.Errorf("got %v, want ErrInvalidPropertyValue", err)
+			}
+			thinkingdatatest.AssertCount(t, c, 0)
+		})
+	}
+}
+
+func TestEventBuilderInvalidSuperProperty(t *testing.T) {
+	c := thinkingdatatest.NewRecordingConsumer()
+	ta := thinkingdata.New(c)
+	ta.SetSuperProperties(map[string]interface{}{"#app_id": 1})
+
+	err := ta.Event("purchase").Account("A1").Send()
+	var validationErr *thinkingdata.ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Key != "#app_id" {
+		t.Fatalf("got %v, want a ValidationError of #app_id", err)
+	}
+	thinkingdatatest.AssertCount(t, c, 0)
+}
@@ -0,0 +1,45 @@
+package thinkingdata
+
+// TDInterceptor process the data of track and user operations before it's checked and handed over to the consumer.
+// It returns the data to go on with, which may be modified or replaced. Return an error to drop the data,
+// the error is returned to the caller of Track / User* methods, NewDropError helps to describe the reason.
+type TDInterceptor func(d *Data) (*Data, error)
+
+// AddInterceptor append interceptors to the chain, they are called in the order of adding.
+func (ta *TDAnalytics) AddInterceptor(interceptors ...TDInterceptor) {
+	ta.mutex.Lock()
+	for _, i := range interceptors {
+		if i != nil {
+			ta.interceptors = append(ta.interceptors, i)
+		}
+	}
+	ta.mutex.Unlock()
+}
+
+// ClearInterceptors remove all the interceptors
+func (ta *TDAnalytics) ClearInterceptors() {
+	ta.mutex.Lock()
+	ta.interceptors = nil
+	ta.mutex.Unlock()
+}
+
+// intercept run the data through the interceptor chain, the chain stops at the first error.
+func (ta *TDAnalytics) intercept(d *Data) (*Data, error) {
+	ta.mutex.RLock()
+	interceptors := ta.interceptors
+	ta.mutex.RUnlock()
+
+	var err error
+	eventName := d.EventName
+	for _, interceptor := range interceptors {
+		d, err = interceptor(d)
+		if err == nil && d == nil {
+			err = NewDropError("interceptor returned nil data")
+		}
+		if err != nil {
+			ta.log().with(logAttr(LogKeyEventName, eventName), logAttr(LogKeyError, err)).info("data is dropped by interceptor: %s", err.Error())
+			return nil, err
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,57 @@
+package thinkingdata_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata/thinkingdatatest"
+)
+
+func TestInterceptors(t *testing.T) {
+	c := thinkingdatatest.NewRecordingConsumer()
+	ta := thinkingdata.New(c)
+	var order []string
+	ta.AddInterceptor(func(d *thinkingdata.Data) (*thinkingdata.Data, error) {
+		order = append(order, "first")
+		d.Properties["env"] = "test"
+		return d, nil
+	}, func(d *thinkingdata.Data) (*thinkingdata.Data, error) {
+		order = append(order, "second")
+		if d.EventName == "debug" {
+			return nil, thinkingdata.NewDropError("debug event")
+		}
+		if d.EventName == "internal" {
+			return nil, nil
+		}
+		// the data can be replaced
+		replaced := *d
+		replaced.EventName = d.EventName + "_v2"
+		return &replaced, nil
+	})
+
+	if err := ta.Track("A1", "", "purchase", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("got order %v", order)
+	}
+	d := thinkingdatatest.AssertEvent(t, c, "purchase_v2")
+	thinkingdatatest.AssertProperty(t, d, "env", "test")
+
+	for _, eventName := range []string{"debug", "internal"} {
+		err := ta.Track("A1", "", eventName, nil)
+		var dropErr *thinkingdata.DropError
+		if !errors.Is(err, thinkingdata.ErrEventDropped) || !errors.As(err, &dropErr) {
+			t.Errorf("got %v for %s, want a DropError", err, eventName)
+		}
+	}
+	thinkingdatatest.AssertCount(t, c, 1)
+
+	ta.ClearInterceptors()
+	if err := ta.Track("A1", "", "debug", nil); err != nil {
+		t.Fatal(err)
+	}
+	d = thinkingdatatest.AssertEvent(t, c, "debug")
+	thinkingdatatest.AssertNoProperty(t, d, "env")
+}
@@ -0,0 +1,163 @@
+package thinkingdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rules of TDLinter
+const (
+	LintInvalidJson      = "invalid_json"
+	LintInvalidType      = "invalid_type"
+	LintMissingUser      = "missing_user"
+	LintInvalidTime      = "invalid_time"
+	LintInvalidEventName = "invalid_event_name"
+	LintMissingEventId   = "missing_event_id"
+	LintInvalidKey       = "invalid_property_key"
+	LintReservedKey      = "reserved_property_key"
+	LintNotNumber        = "user_add_not_number"
+	LintTypeMismatch     = "type_mismatch"
+)
+
+// preset properties which are allowed in properties, the other keys starting with '#' are reserved by the receiver
+var lintPresetKeys = map[string]bool{
+	"#lib":         true,
+	"#lib_version": true,
+	"#zone_offset": true,
+}
+
+// LintIssue a problem found by TDLinter
+type LintIssue struct {
+	Line    int    `json:"line"`          // line number, starting from 1
+	Rule    string `json:"rule"`          // one of the Lint* rules
+	Key     string `json:"key,omitempty"` // the offending key
+	Message string `json:"message"`
+}
+
+// TDLinter check the lines written by TDLogConsumer with the rules of SDK, and stricter rules which are
+// otherwise only checked by the receiver. The types of properties are remembered across lines,
+// use one TDLinter for the files of a project. Event properties and user properties are separate tables,
+// so a property of the same name may have different types in them.
+type TDLinter struct {
+	propertyTypes map[lintProperty]lintType
+}
+
+// lintProperty identify a property in the event table or the user table
+type lintProperty struct {
+	user bool
+	name string
+}
+
+type lintType struct {
+	name   string // type of the property
+	source string // where the type is seen first
+}
+
+func NewLinter() *TDLinter {
+	return &TDLinter{propertyTypes: make(map[lintProperty]lintType)}
+}
+
+// LintLine check a line, source is used to describe where the type of a property is seen first, e.g. "log.2023-01-01:12".
+func (l *TDLinter) LintLine(line []byte, lineNo int, source string) []LintIssue {
+	d, err := decodeData(line)
+	if err != nil {
+		return []LintIssue{{Line: lineNo, Rule: LintInvalidJson, Message: err.Error()}}
+	}
+
+	var issues []LintIssue
+	report := func(rule, key, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{Line: lineNo, Rule: rule, Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	isEvent, isUser := false, false
+	switch d.Type {
+	case Track, TrackUpdate, TrackOverwrite:
+		isEvent = true
+	case UserSet, UserUnset, UserSetOnce, UserAdd, UserAppend, UserUniqAppend, UserDel:
+		isUser = true
+	default:
+		report(LintInvalidType, "#type", "unknown type %q", d.Type)
+	}
+
+	if len(d.AccountId) == 0 && len(d.DistinctId) == 0 {
+		report(LintMissingUser, "", ErrEmptyUserId.Error())
+	}
+	if _, err := time.Parse(DATE_FORMAT, d.Time); err != nil {
+		report(LintInvalidTime, "#time", "%q is not in the format %s", d.Time, DATE_FORMAT)
+	}
+	if isEvent {
+		if len(d.EventName) == 0 {
+			report(LintInvalidEventName, "#event_name", ErrEmptyEventName.Error())
+		} else if !checkPattern([]byte(d.EventName)) {
+			report(LintInvalidEventName, "#event_name", "%q doesn't match %s", d.EventName, KEY_PATTERN)
+		}
+		if d.Type != Track && len(d.EventId) == 0 {
+			report(LintMissingEventId, "#event_id", ErrEmptyEventId.Error())
+		}
+	}
+
+	for _, k := range sortedPropertyKeys(d.Properties) {
+		v := d.Properties[k]
+		if !checkPattern([]byte(k)) {
+			report(LintInvalidKey, k, "%q doesn't match %s", k, KEY_PATTERN)
+		} else if strings.HasPrefix(k, "#") && !lintPresetKeys[k] {
+			report(LintReservedKey, k, "%q is reserved by the receiver", k)
+		}
+
+		typeName := lintTypeOf(v)
+		if d.Type == UserAdd && typeName != "number" {
+			report(LintNotNumber, k, "only numbers is supported by UserAdd, got %s", typeName)
+			continue
+		}
+		if d.Type == UserUnset || d.Type == UserDel || typeName == "null" {
+			// the values are placeholders
+			continue
+		}
+		if !isEvent && !isUser {
+			// the table of the property is unknown
+			continue
+		}
+		property := lintProperty{user: isUser, name: k}
+		if seen, ok := l.propertyTypes[property]; !ok {
+			l.propertyTypes[property] = lintType{name: typeName, source: source}
+		} else if seen.name != typeName {
+			report(LintTypeMismatch, k, "type is %s, but %s at %s", typeName, seen.name, seen.source)
+		}
+	}
+	return issues
+}
+
+// lintTypeOf the type of a property value which is decoded by decodeData, strings in DATE_FORMAT are times.
+func lintTypeOf(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case json.Number:
+		return "number"
+	case string:
+		if _, err := time.Parse(DATE_FORMAT, value); err == nil {
+			return "time"
+		}
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func sortedPropertyKeys(p map[string]interface{}) []string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,52 @@
+//go:build go1.21
+// +build go1.21
+
+package thinkingdata
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// TDSlogLogger route the logs of the SDK to a slog.Handler, the attributes of the logs are passed as slog attributes.
+// e.g. SetCustomLogger(NewSlogLogger(slog.Default().Handler()))
+type TDSlogLogger struct {
+	handler slog.Handler
+}
+
+func NewSlogLogger(handler slog.Handler) *TDSlogLogger {
+	return &TDSlogLogger{handler: handler}
+}
+
+// Print log a pre-formatted message at slog.LevelInfo.
+func (l *TDSlogLogger) Print(message string) {
+	l.Log(TDLogLevelInfo, strings.TrimSuffix(message, "\n"))
+}
+
+func (l *TDSlogLogger) Log(level TDLogLevel, message string, attrs ...TDLogAttr) {
+	ctx := context.Background()
+	slogLevel := slogLevelOf(level)
+	if !l.handler.Enabled(ctx, slogLevel) {
+		return
+	}
+	record := slog.NewRecord(time.Now(), slogLevel, message, 0)
+	for _, attr := range attrs {
+		record.AddAttrs(slog.Any(attr.Key, attr.Value))
+	}
+	_ = l.handler.Handle(ctx, record)
+}
+
+func slogLevelOf(level TDLogLevel) slog.Level {
+	switch level {
+	case TDLogLevelError:
+		return slog.LevelError
+	case TDLogLevelWarning:
+		return slog.LevelWarn
+	case TDLogLevelDebug:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
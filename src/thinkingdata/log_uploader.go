@@ -0,0 +1,265 @@
+package thinkingdata
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	DefaultUploadInterval = 10
+	DefaultCheckpointName = ".tdupload.checkpoint"
+)
+
+// TDLogUploader upload the files written by TDLogConsumer to the receiver, like LogBus does.
+// The offsets of the files are saved in a checkpoint file, so a restarted uploader continues where it stopped.
+// Only complete lines are uploaded, the last line being written by TDLogConsumer is left to the next scan.
+type TDLogUploader struct {
+	directory      string
+	fileNamePrefix string
+	checkpointFile string
+	interval       time.Duration
+	consumer       *TDBatchConsumer
+	offsets        map[string]int64 // uploaded bytes of the files, keyed by file name
+	logger         *instanceLogger
+}
+
+type TDLogUploaderConfig struct {
+	Directory      string         // directory of the files, the same as TDLogConsumerConfig.Directory
+	FileNamePrefix string         // prefix of the files, the same as TDLogConsumerConfig.FileNamePrefix
+	CheckpointFile string         // path of the checkpoint file, DefaultCheckpointName in Directory by default
+	Interval       int            // spacing of scanning the files (second)
+	ServerUrl      string         // serverUrl
+	AppId          string         // appId, used for the data without "#app_id"
+	BatchSize      int            // upload event count each time
+	Timeout        int            // http timeout (mill second)
+	Compress       bool           // enable compress data
+	RetryPolicy    *TDRetryPolicy // retry policy of uploading, DefaultRetryPolicy is used when nil
+	Logger         TDLogger       // logger of the uploader, the logger set by SetCustomLogger is used when nil
+	LogLevel       TDLogLevel     // log level of the uploader, the level set by SetLogLevel is used when 0
+}
+
+// TDLogUploaderStats result of an upload round
+type TDLogUploaderStats struct {
+	Files        int // count of files with new lines
+	Events       int // count of uploaded events
+	InvalidLines int // count of skipped lines which are not valid data
+}
+
+// NewLogUploader create TDLogUploader for the files in the directory, the data is compressed.
+func NewLogUploader(directory, serverUrl, appId string) (*TDLogUploader, error) {
+	return NewLogUploaderWithConfig(TDLogUploaderConfig{
+		Directory: directory,
+		ServerUrl: serverUrl,
+		AppId:     appId,
+		Compress:  true,
+	})
+}
+
+func NewLogUploaderWithConfig(config TDLogUploaderConfig) (*TDLogUploader, error) {
+	logger := newInstanceLogger(config.Logger, config.LogLevel)
+	if len(config.Directory) == 0 {
+		err := fmt.Errorf("%w: directory not be empty", ErrInvalidParams)
+		logger.error(err.Error())
+		return nil, err
+	}
+	consumer, err := initBatchConsumer(TDBatchConfig{
+		ServerUrl:   config.ServerUrl,
+		AppId:       config.AppId,
+		BatchSize:   config.BatchSize,
+		Timeout:     config.Timeout,
+		Compress:    config.Compress,
+		RetryPolicy: config.RetryPolicy,
+		Logger:      config.Logger,
+		LogLevel:    config.LogLevel,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	interval := config.Interval
+	if interval <= 0 {
+		interval = DefaultUploadInterval
+	}
+	checkpointFile := config.CheckpointFile
+	if len(checkpointFile) == 0 {
+		checkpointFile = filepath.Join(config.Directory, DefaultCheckpointName)
+	}
+
+	u := &TDLogUploader{
+		directory:      config.Directory,
+		fileNamePrefix: config.FileNamePrefix,
+		checkpointFile: checkpointFile,
+		interval:       time.Duration(interval) * time.Second,
+		consumer:       consumer.(*TDBatchConsumer),
+		offsets:        make(map[string]int64),
+		logger:         logger,
+	}
+	if err := u.loadCheckpoint(); err != nil {
+		u.logger.with(logAttr(LogKeyFile, checkpointFile), logAttr(LogKeyError, err)).error("load checkpoint failed: %s", err)
+		return nil, err
+	}
+
+	u.logger.info("Mode: log uploader, path: %s, checkpoint: %s", u.directory, u.checkpointFile)
+	return u, nil
+}
+
+// Run upload the files until the context is done.
+func (u *TDLogUploader) Run(ctx context.Context) error {
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := u.UploadOnce(ctx); err != nil && ctx.Err() == nil {
+			u.logger.with(receiverErrorAttrs(err)...).error("upload log files failed: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// UploadOnce upload the new lines of all the files once. It stops at the first batch which can't be uploaded,
+// the lines before it are checkpointed.
+func (u *TDLogUploader) UploadOnce(ctx context.Context) (TDLogUploaderStats, error) {
+	var stats TDLogUploaderStats
+	files, err := listLogFiles(u.directory, u.fileNamePrefix)
+	if err != nil {
+		return stats, err
+	}
+
+	existing := make(map[string]bool, len(files))
+	for _, name := range files {
+		existing[filepath.Base(name)] = true
+	}
+	for name := range u.offsets {
+		if !existing[name] {
+			// the file is removed, e.g. by the retention of the user
+			delete(u.offsets, name)
+		}
+	}
+
+	for _, name := range files {
+		if err := u.uploadFile(ctx, name, &stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+func (u *TDLogUploader) uploadFile(ctx context.Context, name string, stats *TDLogUploaderStats) error {
+	key := filepath.Base(name)
+	offset := u.offsets[key]
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < offset {
+		// the file is truncated or replaced, upload it from the beginning
+		u.logger.with(logAttr(LogKeyFile, name)).warning("%s is shorter than the checkpoint, upload it again", name)
+		offset = 0
+	}
+	if info.Size() == offset {
+		return nil
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// ends[i] is the offset after the line of events[i]
+	var events []Data
+	var ends []int64
+	reader := bufio.NewReader(f)
+	end := offset
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// an incomplete line is being written, it's read again next time
+			break
+		}
+		if err != nil {
+			return err
+		}
+		end += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		d, err := decodeData(line)
+		if err != nil {
+			u.logger.with(logAttr(LogKeyFile, name), logAttr(LogKeyError, err)).error("invalid line in %s at offset %d: %s", name, end-int64(len(line)), err)
+			stats.InvalidLines++
+			continue
+		}
+		events = append(events, d)
+		ends = append(ends, end)
+	}
+	if end == offset {
+		return nil
+	}
+	stats.Files++
+
+	sent := 0
+	err = u.consumer.uploadEvents(ctx, events, func(n int) {
+		stats.Events += n - sent
+		sent = n
+		u.offsets[key] = ends[n-1]
+		if saveErr := u.saveCheckpoint(); saveErr != nil {
+			u.logger.with(logAttr(LogKeyFile, u.checkpointFile), logAttr(LogKeyError, saveErr)).error("save checkpoint failed: %s", saveErr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// the skipped lines at the end are checkpointed as well
+	u.offsets[key] = end
+	return u.saveCheckpoint()
+}
+
+type logUploaderCheckpoint struct {
+	Offsets map[string]int64 `json:"offsets"`
+}
+
+func (u *TDLogUploader) loadCheckpoint() error {
+	content, err := ioutil.ReadFile(u.checkpointFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var checkpoint logUploaderCheckpoint
+	if err := json.Unmarshal(content, &checkpoint); err != nil {
+		return err
+	}
+	if checkpoint.Offsets != nil {
+		u.offsets = checkpoint.Offsets
+	}
+	return nil
+}
+
+// saveCheckpoint write the offsets to a temporary file and rename it, so the checkpoint is never half written.
+func (u *TDLogUploader) saveCheckpoint() error {
+	content, err := json.Marshal(logUploaderCheckpoint{Offsets: u.offsets})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(u.checkpointFile+spoolTempSuffix, content, 0664); err != nil {
+		return err
+	}
+	return os.Rename(u.checkpointFile+spoolTempSuffix, u.checkpointFile)
+}
@@ -0,0 +1,263 @@
+package thinkingdata
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// names of the metrics reported by the SDK
+const (
+	MetricEventsTracked  = "events_tracked_total"  // counter, data passed to the SDK, labels: type
+	MetricEventsRejected = "events_rejected_total" // counter, data which failed the checks of the SDK, labels: type
+	MetricEventsDropped  = "events_dropped_total"  // counter, data dropped on purpose, by eviction or by the receiver, labels: reason
+	MetricEventsEnqueued = "events_enqueued_total" // counter, data accepted by a consumer, labels: consumer, app_id
+	MetricEventsSent     = "events_sent_total"     // counter, data accepted by the receiver, labels: consumer, app_id
+	MetricEventsWritten  = "events_written_total"  // counter, data written to files, labels: consumer
+	MetricWriteErrors    = "write_errors_total"    // counter, data which failed to be written to files, labels: consumer
+	MetricBatchesSent    = "batches_sent_total"    // counter, requests accepted by the receiver, labels: consumer, app_id
+	MetricBatchesFailed  = "batches_failed_total"  // counter, requests which failed, labels: consumer, app_id
+	MetricBytesSent      = "bytes_sent_total"      // counter, size of the request bodies, labels: consumer, app_id
+	MetricRetries        = "retries_total"         // counter, retried requests, labels: consumer, app_id
+	MetricSendDuration   = "send_duration_seconds" // histogram, duration of the requests, labels: consumer, app_id
+	MetricBufferDepth    = "buffer_depth"          // gauge, data waiting for a full batch, labels: consumer, app_id
+	MetricCacheDepth     = "cache_depth"           // gauge, batches waiting to be uploaded, labels: consumer
+	MetricCacheCapacity  = "cache_capacity"        // gauge, max count of batches waiting to be uploaded, labels: consumer
+	MetricQueueDepth     = "queue_depth"           // gauge, items in the channel of a consumer, labels: consumer
+	MetricQueueCapacity  = "queue_capacity"        // gauge, capacity of the channel of a consumer, labels: consumer
+)
+
+// names of the labels reported by the SDK
+const (
+	MetricLabelType     = "type"
+	MetricLabelReason   = "reason"
+	MetricLabelConsumer = "consumer"
+	MetricLabelAppId    = "app_id"
+)
+
+// reasons of MetricEventsDropped
+const (
+	DropReasonSampled       = "sampled"
+	DropReasonRateLimited   = "rate_limited"
+	DropReasonInterceptor   = "interceptor"
+	DropReasonCacheCapacity = "cache_capacity"
+	DropReasonRejected      = "rejected"
+)
+
+// DefaultMetricsBuckets upper bounds of the histogram buckets of TDMetricsRegistry (second)
+var DefaultMetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// TDMetrics receive the metrics of the SDK, it must be safe for concurrent use.
+type TDMetrics interface {
+	Counter(name string, delta int64, labels ...TDMetricLabel)
+	Gauge(name string, value float64, labels ...TDMetricLabel)
+	Histogram(name string, value float64, labels ...TDMetricLabel)
+}
+
+// TDMetricLabel name and value of a label
+type TDMetricLabel struct {
+	Name  string
+	Value string
+}
+
+func metricLabel(name, value string) TDMetricLabel {
+	return TDMetricLabel{Name: name, Value: value}
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Counter(string, int64, ...TDMetricLabel) {}
+
+func (noopMetrics) Gauge(string, float64, ...TDMetricLabel) {}
+
+func (noopMetrics) Histogram(string, float64, ...TDMetricLabel) {}
+
+// normalizeMetrics the metrics are discarded when m is nil
+func normalizeMetrics(m TDMetrics) TDMetrics {
+	if m == nil {
+		return noopMetrics{}
+	}
+	return m
+}
+
+// TDMetricValue value of a counter or a gauge
+type TDMetricValue struct {
+	Name   string
+	Labels []TDMetricLabel
+	Value  float64
+}
+
+// TDHistogramValue value of a histogram, the counts of the buckets are cumulative
+type TDHistogramValue struct {
+	Name    string
+	Labels  []TDMetricLabel
+	Count   int64
+	Sum     float64
+	Buckets []TDHistogramBucket
+}
+
+type TDHistogramBucket struct {
+	UpperBound float64
+	Count      int64
+}
+
+// TDMetricsSnapshot values of all the metrics, sorted by name and labels
+type TDMetricsSnapshot struct {
+	Counters   []TDMetricValue
+	Gauges     []TDMetricValue
+	Histograms []TDHistogramValue
+}
+
+// TDMetricsRegistry keep the metrics in memory. Pass it to the SDK instance and the consumers,
+// and read the current queue depths and totals by Snapshot.
+type TDMetricsRegistry struct {
+	mutex      *sync.Mutex
+	buckets    []float64
+	counters   map[string]*TDMetricValue
+	gauges     map[string]*TDMetricValue
+	histograms map[string]*TDHistogramValue
+}
+
+func NewMetricsRegistry() *TDMetricsRegistry {
+	return NewMetricsRegistryWithBuckets(DefaultMetricsBuckets)
+}
+
+// NewMetricsRegistryWithBuckets create TDMetricsRegistry with the upper bounds of the histogram buckets
+func NewMetricsRegistryWithBuckets(buckets []float64) *TDMetricsRegistry {
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+	return &TDMetricsRegistry{
+		mutex:      new(sync.Mutex),
+		buckets:    sorted,
+		counters:   make(map[string]*TDMetricValue),
+		gauges:     make(map[string]*TDMetricValue),
+		histograms: make(map[string]*TDHistogramValue),
+	}
+}
+
+func (r *TDMetricsRegistry) Counter(name string, delta int64, labels ...TDMetricLabel) {
+	key := metricKey(name, labels)
+	r.mutex.Lock()
+	v, ok := r.counters[key]
+	if !ok {
+		v = &TDMetricValue{Name: name, Labels: copyLabels(labels)}
+		r.counters[key] = v
+	}
+	v.Value += float64(delta)
+	r.mutex.Unlock()
+}
+
+func (r *TDMetricsRegistry) Gauge(name string, value float64, labels ...TDMetricLabel) {
+	key := metricKey(name, labels)
+	r.mutex.Lock()
+	v, ok := r.gauges[key]
+	if !ok {
+		v = &TDMetricValue{Name: name, Labels: copyLabels(labels)}
+		r.gauges[key] = v
+	}
+	v.Value = value
+	r.mutex.Unlock()
+}
+
+func (r *TDMetricsRegistry) Histogram(name string, value float64, labels ...TDMetricLabel) {
+	key := metricKey(name, labels)
+	r.mutex.Lock()
+	v, ok := r.histograms[key]
+	if !ok {
+		v = &TDHistogramValue{Name: name, Labels: copyLabels(labels), Buckets: make([]TDHistogramBucket, len(r.buckets))}
+		for i, bound := range r.buckets {
+			v.Buckets[i].UpperBound = bound
+		}
+		r.histograms[key] = v
+	}
+	v.Count++
+	v.Sum += value
+	for i := range v.Buckets {
+		if value <= v.Buckets[i].UpperBound {
+			v.Buckets[i].Count++
+		}
+	}
+	r.mutex.Unlock()
+}
+
+// Snapshot return a copy of the current values
+func (r *TDMetricsRegistry) Snapshot() TDMetricsSnapshot {
+	var snapshot TDMetricsSnapshot
+	r.mutex.Lock()
+	for _, key := range sortedMetricKeys(r.counters) {
+		v := *r.counters[key]
+		v.Labels = copyLabels(v.Labels)
+		snapshot.Counters = append(snapshot.Counters, v)
+	}
+	for _, key := range sortedMetricKeys(r.gauges) {
+		v := *r.gauges[key]
+		v.Labels = copyLabels(v.Labels)
+		snapshot.Gauges = append(snapshot.Gauges, v)
+	}
+	histogramKeys := make([]string, 0, len(r.histograms))
+	for key := range r.histograms {
+		histogramKeys = append(histogramKeys, key)
+	}
+	sort.Strings(histogramKeys)
+	for _, key := range histogramKeys {
+		v := *r.histograms[key]
+		v.Labels = copyLabels(v.Labels)
+		v.Buckets = append([]TDHistogramBucket(nil), v.Buckets...)
+		snapshot.Histograms = append(snapshot.Histograms, v)
+	}
+	r.mutex.Unlock()
+	return snapshot
+}
+
+// Counter the value of a counter, 0 if it's not reported yet
+func (s TDMetricsSnapshot) Counter(name string, labels ...TDMetricLabel) float64 {
+	return findMetricValue(s.Counters, name, labels)
+}
+
+// Gauge the value of a gauge, 0 if it's not reported yet
+func (s TDMetricsSnapshot) Gauge(name string, labels ...TDMetricLabel) float64 {
+	return findMetricValue(s.Gauges, name, labels)
+}
+
+func findMetricValue(values []TDMetricValue, name string, labels []TDMetricLabel) float64 {
+	key := metricKey(name, labels)
+	for _, v := range values {
+		if metricKey(v.Name, v.Labels) == key {
+			return v.Value
+		}
+	}
+	return 0
+}
+
+// metricKey identify a series by the name and the labels, in the order they are given
+func metricKey(name string, labels []TDMetricLabel) string {
+	if len(labels) == 0 {
+		return name
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	for _, l := range labels {
+		b.WriteByte(0)
+		b.WriteString(l.Name)
+		b.WriteByte('=')
+		b.WriteString(l.Value)
+	}
+	return b.String()
+}
+
+func copyLabels(labels []TDMetricLabel) []TDMetricLabel {
+	if len(labels) == 0 {
+		return nil
+	}
+	return append([]TDMetricLabel(nil), labels...)
+}
+
+func sortedMetricKeys(m map[string]*TDMetricValue) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
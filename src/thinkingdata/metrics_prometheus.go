@@ -0,0 +1,144 @@
+package thinkingdata
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PrometheusNamespace prefix of the metric names served by NewPrometheusHandler
+const PrometheusNamespace = "thinkingdata"
+
+// help text of the metrics reported by the SDK
+var prometheusHelp = map[string]string{
+	MetricEventsTracked:  "Data passed to the SDK.",
+	MetricEventsRejected: "Data which failed the checks of the SDK.",
+	MetricEventsDropped:  "Data dropped by sampling, interceptors, cache eviction or the receiver.",
+	MetricEventsEnqueued: "Data accepted by a consumer.",
+	MetricEventsSent:     "Data accepted by the receiver.",
+	MetricEventsWritten:  "Data written to files.",
+	MetricWriteErrors:    "Data which failed to be written to files.",
+	MetricBatchesSent:    "Requests accepted by the receiver.",
+	MetricBatchesFailed:  "Requests which failed.",
+	MetricBytesSent:      "Size of the request bodies in bytes.",
+	MetricRetries:        "Retried requests.",
+	MetricSendDuration:   "Duration of the requests in seconds.",
+	MetricBufferDepth:    "Data waiting for a full batch.",
+	MetricCacheDepth:     "Batches waiting to be uploaded.",
+	MetricCacheCapacity:  "Max count of batches waiting to be uploaded.",
+	MetricQueueDepth:     "Items in the channel of a consumer.",
+	MetricQueueCapacity:  "Capacity of the channel of a consumer.",
+}
+
+// NewPrometheusHandler serve the metrics of the registry in the Prometheus text exposition format.
+func NewPrometheusHandler(registry *TDMetricsRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = registry.Snapshot().WritePrometheus(w)
+	})
+}
+
+// WritePrometheus write the snapshot in the Prometheus text exposition format, the names are prefixed with PrometheusNamespace.
+func (s TDMetricsSnapshot) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	last := ""
+	for _, v := range s.Counters {
+		name := prometheusName(v.Name)
+		if name != last {
+			writePrometheusHeader(bw, name, v.Name, "counter")
+			last = name
+		}
+		writePrometheusSample(bw, name, v.Labels, "", "", v.Value)
+	}
+	for _, v := range s.Gauges {
+		name := prometheusName(v.Name)
+		if name != last {
+			writePrometheusHeader(bw, name, v.Name, "gauge")
+			last = name
+		}
+		writePrometheusSample(bw, name, v.Labels, "", "", v.Value)
+	}
+	for _, v := range s.Histograms {
+		name := prometheusName(v.Name)
+		if name != last {
+			writePrometheusHeader(bw, name, v.Name, "histogram")
+			last = name
+		}
+		for _, b := range v.Buckets {
+			writePrometheusSample(bw, name+"_bucket", v.Labels, "le", formatPrometheusValue(b.UpperBound), float64(b.Count))
+		}
+		writePrometheusSample(bw, name+"_bucket", v.Labels, "le", "+Inf", float64(v.Count))
+		writePrometheusSample(bw, name+"_sum", v.Labels, "", "", v.Sum)
+		writePrometheusSample(bw, name+"_count", v.Labels, "", "", float64(v.Count))
+	}
+	return bw.Flush()
+}
+
+func writePrometheusHeader(w *bufio.Writer, name, metric, metricType string) {
+	if help, ok := prometheusHelp[metric]; ok {
+		w.WriteString("# HELP " + name + " " + help + "\n")
+	}
+	w.WriteString("# TYPE " + name + " " + metricType + "\n")
+}
+
+// writePrometheusSample write a line of a sample, extraName and extraValue is an additional label, e.g. "le" of buckets.
+func writePrometheusSample(w *bufio.Writer, name string, labels []TDMetricLabel, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || len(extraName) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writePrometheusLabel(w, sanitizePrometheusName(l.Name), l.Value)
+		}
+		if len(extraName) > 0 {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			writePrometheusLabel(w, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatPrometheusValue(value))
+	w.WriteByte('\n')
+}
+
+var prometheusLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writePrometheusLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	prometheusLabelReplacer.WriteString(w, value)
+	w.WriteByte('"')
+}
+
+func formatPrometheusValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func prometheusName(name string) string {
+	return PrometheusNamespace + "_" + sanitizePrometheusName(name)
+}
+
+// sanitizePrometheusName replace the characters which are not allowed in the names with '_'
+func sanitizePrometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
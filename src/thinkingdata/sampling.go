@@ -0,0 +1,123 @@
+package thinkingdata
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+type TDSampleBy int32
+
+const (
+	SampleByDistinctId TDSampleBy = 0 // hash of distinct id, account id is used when distinct id is empty
+	SampleByAccountId  TDSampleBy = 1 // hash of account id, distinct id is used when account id is empty
+)
+
+// TDSamplingRule sample and limit the data which matches EventName and Type.
+// Sampling is deterministic, a user is either always in or always out of the sample.
+type TDSamplingRule struct {
+	EventName    string     // event name the rule applies to, empty matches all
+	Type         string     // data type the rule applies to, e.g. Track or UserSet, empty matches all
+	SampleRate   float64    // fraction of users to keep, (0, 1). Other values keep all users
+	SampleBy     TDSampleBy // which id is hashed for sampling
+	RateProperty string     // name of the property to record SampleRate in, empty means not to record
+	MaxPerSecond int        // max count of data reported per second, 0 means unlimited
+}
+
+type sampler struct {
+	rule   TDSamplingRule
+	mutex  *sync.Mutex
+	second int64 // current window of rate limiting
+	count  int   // count of data in the window
+}
+
+// SetSamplingRules replace the sampling rules, data is checked against the first matching rule.
+// Sampled out data is discarded silently, and ErrRateLimited is returned when the rate limit is exceeded.
+func (ta *TDAnalytics) SetSamplingRules(rules ...TDSamplingRule) {
+	samplers := make([]*sampler, 0, len(rules))
+	for _, rule := range rules {
+		samplers = append(samplers, &sampler{rule: rule, mutex: new(sync.Mutex)})
+	}
+	ta.mutex.Lock()
+	ta.samplers = samplers
+	ta.mutex.Unlock()
+}
+
+// sample return whether the data should be kept, or an error if the rate limit is exceeded.
+func (ta *TDAnalytics) sample(d *Data) (bool, error) {
+	ta.mutex.RLock()
+	samplers := ta.samplers
+	ta.mutex.RUnlock()
+
+	for _, s := range samplers {
+		if s.match(d) {
+			return s.apply(d, ta.log())
+		}
+	}
+	return true, nil
+}
+
+func (s *sampler) match(d *Data) bool {
+	if len(s.rule.EventName) > 0 && s.rule.EventName != d.EventName {
+		return false
+	}
+	if len(s.rule.Type) > 0 && s.rule.Type != d.Type {
+		return false
+	}
+	return true
+}
+
+func (s *sampler) apply(d *Data, logger *instanceLogger) (bool, error) {
+	rate := s.rule.SampleRate
+	if rate > 0 && rate < 1 {
+		if !inSample(s.sampleKey(d), rate) {
+			logger.with(logAttr(LogKeyEventName, d.EventName)).debug("data is sampled out: %s %s", d.Type, d.EventName)
+			return false, nil
+		}
+		if len(s.rule.RateProperty) > 0 {
+			if d.Properties == nil {
+				d.Properties = make(map[string]interface{})
+			}
+			d.Properties[s.rule.RateProperty] = rate
+		}
+	}
+
+	if s.rule.MaxPerSecond > 0 {
+		now := time.Now().Unix()
+		s.mutex.Lock()
+		if now != s.second {
+			s.second = now
+			s.count = 0
+		}
+		s.count++
+		exceeded := s.count > s.rule.MaxPerSecond
+		s.mutex.Unlock()
+		if exceeded {
+			logger.with(logAttr(LogKeyEventName, d.EventName)).debug("rate limit exceeded: %s %s", d.Type, d.EventName)
+			return false, ErrRateLimited
+		}
+	}
+	return true, nil
+}
+
+func (s *sampler) sampleKey(d *Data) string {
+	if (s.rule.SampleBy == SampleByAccountId && len(d.AccountId) > 0) || len(d.DistinctId) == 0 {
+		return d.AccountId
+	}
+	return d.DistinctId
+}
+
+// inSample map the hash of key to [0, 1) and compare it with rate
+func inSample(key string, rate float64) bool {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	// fnv has poor avalanche on the high bits of short keys, mix them before scaling
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return float64(x)/math.MaxUint64 < rate
+}
@@ -0,0 +1,85 @@
+package thinkingdata_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata/thinkingdatatest"
+)
+
+func TestSampling(t *testing.T) {
+	c := thinkingdatatest.NewRecordingConsumer()
+	ta := thinkingdata.New(c)
+	ta.SetSamplingRules(thinkingdata.TDSamplingRule{
+		EventName:    "view",
+		SampleRate:   0.5,
+		SampleBy:     thinkingdata.SampleByAccountId,
+		RateProperty: "sample_rate",
+	})
+
+	const users = 1000
+	for i := 0; i < users; i++ {
+		if err := ta.Track("A"+strconv.Itoa(i), "D", "view", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept := c.Len()
+	if kept < users*4/10 || kept > users*6/10 {
+		t.Fatalf("got %d of %d users, want about half", kept, users)
+	}
+	for _, d := range c.Data() {
+		thinkingdatatest.AssertProperty(t, d, "sample_rate", 0.5)
+	}
+
+	// a user is either always in or always out of the sample
+	c.Reset()
+	for i := 0; i < users; i++ {
+		ta.Track("A"+strconv.Itoa(i), "D", "view", nil)
+	}
+	if c.Len() != kept {
+		t.Errorf("got %d users the second time, want %d", c.Len(), kept)
+	}
+
+	// the data which doesn't match the rule is kept
+	c.Reset()
+	for i := 0; i < users; i++ {
+		ta.Track("A"+strconv.Itoa(i), "D", "purchase", nil)
+	}
+	thinkingdatatest.AssertCount(t, c, users)
+	thinkingdatatest.AssertNoProperty(t, c.Data()[0], "sample_rate")
+}
+
+func TestSamplingRateLimit(t *testing.T) {
+	c := thinkingdatatest.NewRecordingConsumer()
+	ta := thinkingdata.New(c)
+	ta.SetSamplingRules(thinkingdata.TDSamplingRule{Type: thinkingdata.UserSet, MaxPerSecond: 3})
+
+	limited := 0
+	for i := 0; i < 10; i++ {
+		err := ta.UserSet("A1", "", map[string]interface{}{"level": i})
+		if errors.Is(err, thinkingdata.ErrRateLimited) {
+			limited++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the loop may cross into the next second
+	if kept := c.Len(); kept < 3 || kept > 6 || kept+limited != 10 {
+		t.Errorf("got %d kept and %d limited", kept, limited)
+	}
+
+	// events are not limited by the rule of UserSet
+	for i := 0; i < 10; i++ {
+		if err := ta.Track("A1", "", "purchase", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	thinkingdatatest.AssertCount(t, c, 10, thinkingdatatest.ByEventName("purchase"))
+
+	ta.SetSamplingRules()
+	if err := ta.UserSet("A1", "", map[string]interface{}{"level": 10}); err != nil {
+		t.Fatal(err)
+	}
+}
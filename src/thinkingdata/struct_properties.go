@@ -0,0 +1,284 @@
+package thinkingdata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// The struct tag to name properties, e.g. `td:"user_level,omitempty"`. A field with tag `td:"-"` is ignored.
+// Without the tag, the field name is used as property name. Fields of embedded structs are promoted, an outer field
+// takes precedence over a promoted one of the same name, as in encoding/json. time.Time is reported as time,
+// nested structs are reported as objects, slices and arrays as lists.
+// With "omitempty", false, 0, "", nil pointers, empty slices and maps, and zero time.Time are omitted.
+const structTagName = "td"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// TrackStruct report ordinary event, the properties are read from the struct v.
+func (ta *TDAnalytics) TrackStruct(accountId, distinctId, eventName string, v interface{}) error {
+	return ta.TrackStructCtx(context.Background(), accountId, distinctId, eventName, v)
+}
+
+// TrackStructCtx is TrackStruct with a context passed down to the consumer.
+func (ta *TDAnalytics) TrackStructCtx(ctx context.Context, accountId, distinctId, eventName string, v interface{}) error {
+	p, err := ta.structToProperties(v)
+	if err != nil {
+		return err
+	}
+	return ta.TrackCtx(ctx, accountId, distinctId, eventName, p)
+}
+
+// TrackUpdateStruct report updatable event, the properties are read from the struct v.
+func (ta *TDAnalytics) TrackUpdateStruct(accountId, distinctId, eventName, eventId string, v interface{}) error {
+	return ta.TrackUpdateStructCtx(context.Background(), accountId, distinctId, eventName, eventId, v)
+}
+
+// TrackUpdateStructCtx is TrackUpdateStruct with a context passed down to the consumer.
+func (ta *TDAnalytics) TrackUpdateStructCtx(ctx context.Context, accountId, distinctId, eventName, eventId string, v interface{}) error {
+	p, err := ta.structToProperties(v)
+	if err != nil {
+		return err
+	}
+	return ta.TrackUpdateCtx(ctx, accountId, distinctId, eventName, eventId, p)
+}
+
+// TrackOverwriteStruct report overridable event, the properties are read from the struct v.
+func (ta *TDAnalytics) TrackOverwriteStruct(accountId, distinctId, eventName, eventId string, v interface{}) error {
+	return ta.TrackOverwriteStructCtx(context.Background(), accountId, distinctId, eventName, eventId, v)
+}
+
+// TrackOverwriteStructCtx is TrackOverwriteStruct with a context passed down to the consumer.
+func (ta *TDAnalytics) TrackOverwriteStructCtx(ctx context.Context, accountId, distinctId, eventName, eventId string, v interface{}) error {
+	p, err := ta.structToProperties(v)
+	if err != nil {
+		return err
+	}
+	return ta.TrackOverwriteCtx(ctx, accountId, distinctId, eventName, eventId, p)
+}
+
+// UserSetStruct set user properties which are read from the struct v.
+func (ta *TDAnalytics) UserSetStruct(accountId string, distinctId string, v interface{}) error {
+	return ta.UserSetStructCtx(context.Background(), accountId, distinctId, v)
+}
+
+// UserSetStructCtx is UserSetStruct with a context passed down to the consumer.
+func (ta *TDAnalytics) UserSetStructCtx(ctx context.Context, accountId string, distinctId string, v interface{}) error {
+	return ta.userStruct(ctx, accountId, distinctId, UserSet, v)
+}
+
+// UserSetOnceStruct set user properties once, the properties are read from the struct v.
+func (ta *TDAnalytics) UserSetOnceStruct(accountId string, distinctId string, v interface{}) error {
+	return ta.UserSetOnceStructCtx(context.Background(), accountId, distinctId, v)
+}
+
+// UserSetOnceStructCtx is UserSetOnceStruct with a context passed down to the consumer.
+func (ta *TDAnalytics) UserSetOnceStructCtx(ctx context.Context, accountId string, distinctId string, v interface{}) error {
+	return ta.userStruct(ctx, accountId, distinctId, UserSetOnce, v)
+}
+
+// UserAddStruct accumulate user properties which are read from the struct v. Only numbers are supported.
+func (ta *TDAnalytics) UserAddStruct(accountId string, distinctId string, v interface{}) error {
+	return ta.UserAddStructCtx(context.Background(), accountId, distinctId, v)
+}
+
+// UserAddStructCtx is UserAddStruct with a context passed down to the consumer.
+func (ta *TDAnalytics) UserAddStructCtx(ctx context.Context, accountId string, distinctId string, v interface{}) error {
+	return ta.userStruct(ctx, accountId, distinctId, UserAdd, v)
+}
+
+// UserAppendStruct append user properties of array type, the properties are read from the struct v.
+func (ta *TDAnalytics) UserAppendStruct(accountId string, distinctId string, v interface{}) error {
+	return ta.UserAppendStructCtx(context.Background(), accountId, distinctId, v)
+}
+
+// UserAppendStructCtx is UserAppendStruct with a context passed down to the consumer.
+func (ta *TDAnalytics) UserAppendStructCtx(ctx context.Context, accountId string, distinctId string, v interface{}) error {
+	return ta.userStruct(ctx, accountId, distinctId, UserAppend, v)
+}
+
+// UserUniqAppendStruct append user properties of array type by unique, the properties are read from the struct v.
+func (ta *TDAnalytics) UserUniqAppendStruct(accountId string, distinctId string, v interface{}) error {
+	return ta.UserUniqAppendStructCtx(context.Background(), accountId, distinctId, v)
+}
+
+// UserUniqAppendStructCtx is UserUniqAppendStruct with a context passed down to the consumer.
+func (ta *TDAnalytics) UserUniqAppendStructCtx(ctx context.Context, accountId string, distinctId string, v interface{}) error {
+	return ta.userStruct(ctx, accountId, distinctId, UserUniqAppend, v)
+}
+
+func (ta *TDAnalytics) userStruct(ctx context.Context, accountId, distinctId, dataType string, v interface{}) error {
+	p, err := ta.structToProperties(v)
+	if err != nil {
+		return err
+	}
+	return ta.user(ctx, accountId, distinctId, dataType, p)
+}
+
+// structToProperties convert a struct, or a pointer to struct, to properties.
+func (ta *TDAnalytics) structToProperties(v interface{}) (map[string]interface{}, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			break
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || rv.Type() == timeType {
+		err := fmt.Errorf("%w: properties must be a struct, got %T", ErrInvalidParams, v)
+		ta.log().error(err.Error())
+		return nil, err
+	}
+
+	p := make(map[string]interface{})
+	if err := structFields(rv, p, make(map[uintptr]bool)); err != nil {
+		ta.log().error(err.Error())
+		return nil, err
+	}
+	return p, nil
+}
+
+// structFields add the fields of the struct to p. Like encoding/json, the shallowest field wins when the fields
+// of embedded structs have the same name, then the tagged one, and the names which are still ambiguous are dropped.
+// A field which can't be converted is reported by ValidationError, the key of a nested field is joined by ".".
+func structFields(rv reflect.Value, p map[string]interface{}, visited map[uintptr]bool) error {
+	for _, f := range cachedFields(rv.Type(), structTagName) {
+		fv, ok := fieldByIndex(rv, f.index)
+		if !ok || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		value, err := convertValue(fv, visited)
+		if err != nil {
+			var nested *ValidationError
+			if errors.As(err, &nested) {
+				nested.Key = f.name + "." + nested.Key
+				return nested
+			}
+			var v interface{}
+			if fv.CanInterface() {
+				v = fv.Interface()
+			}
+			return &ValidationError{Key: f.name, Value: v, Reason: fmt.Sprintf("field %s: %s", f.name, err), Err: ErrInvalidPropertyValue}
+		}
+		p[f.name] = value
+	}
+	return nil
+}
+
+// convertValue convert a reflected value to the types which are supported by properties.
+// visited holds the pointers, maps and slices on the current path, a cyclic value is reported as an error.
+func convertValue(rv reflect.Value, visited map[uintptr]bool) (interface{}, error) {
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return convertValue(rv.Elem(), visited)
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		if visited[rv.Pointer()] {
+			return nil, errConvertCycle(rv.Type())
+		}
+		visited[rv.Pointer()] = true
+		defer delete(visited, rv.Pointer())
+		return convertValue(rv.Elem(), visited)
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Struct:
+		if rv.Type() == timeType {
+			if !rv.CanInterface() {
+				return nil, fmt.Errorf("time.Time in unexported struct is not supported")
+			}
+			return rv.Interface().(time.Time), nil
+		}
+		m := make(map[string]interface{})
+		if err := structFields(rv, m, visited); err != nil {
+			return nil, err
+		}
+		return m, nil
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice {
+			if rv.IsNil() {
+				return nil, nil
+			}
+			if visited[rv.Pointer()] {
+				return nil, errConvertCycle(rv.Type())
+			}
+			visited[rv.Pointer()] = true
+			defer delete(visited, rv.Pointer())
+		}
+		list := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			item, err := convertValue(rv.Index(i), visited)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = item
+		}
+		return list, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", rv.Type().Key())
+		}
+		if visited[rv.Pointer()] {
+			return nil, errConvertCycle(rv.Type())
+		}
+		visited[rv.Pointer()] = true
+		defer delete(visited, rv.Pointer())
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			item, err := convertValue(iter.Value(), visited)
+			if err != nil {
+				return nil, err
+			}
+			m[iter.Key().String()] = item
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", rv.Type())
+	}
+}
+
+func errConvertCycle(t reflect.Type) error {
+	return fmt.Errorf("cyclic value of type %s", t)
+}
+
+func isEmptyValue(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return rv.IsNil()
+	case reflect.Struct:
+		if rv.Type() == timeType && rv.CanInterface() {
+			return rv.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
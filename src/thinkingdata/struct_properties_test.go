@@ -0,0 +1,60 @@
+package thinkingdata
+
+import (
+	"errors"
+	"testing"
+)
+
+type structNode struct {
+	Name string                 `td:"name"`
+	Next *structNode            `td:"next,omitempty"`
+	Tags map[string]interface{} `td:"tags,omitempty"`
+}
+
+func TestStructToPropertiesCyclic(t *testing.T) {
+	loop := &structNode{Name: "loop"}
+	loop.Next = loop
+	cyclicMap := map[string]interface{}{}
+	cyclicMap["self"] = cyclicMap
+	cyclicList := []interface{}{nil}
+	cyclicList[0] = cyclicList
+
+	cases := []struct {
+		name string
+		v    interface{}
+		key  string
+	}{
+		{"pointer", loop, "next.next"},
+		{"map", structNode{Tags: cyclicMap}, "tags"},
+		{"slice", structNode{Tags: map[string]interface{}{"list": cyclicList}}, "tags"},
+	}
+	ta := New(nil)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ta.structToProperties(c.v)
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("got %v, want a ValidationError", err)
+			}
+			if !errors.Is(err, ErrInvalidPropertyValue) || validationErr.Key != c.key {
+				t.Errorf("got %v of key %q, want ErrInvalidPropertyValue of key %q", err, validationErr.Key, c.key)
+			}
+		})
+	}
+}
+
+func TestStructToPropertiesShared(t *testing.T) {
+	// the same pointer in two fields is not a cycle
+	shared := &structNode{Name: "shared"}
+	ta := New(nil)
+	p, err := ta.structToProperties(struct {
+		A *structNode `td:"a"`
+		B *structNode `td:"b"`
+	}{A: shared, B: shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p["a"].(map[string]interface{})["name"] != "shared" || p["b"].(map[string]interface{})["name"] != "shared" {
+		t.Errorf("unexpected properties %v", p)
+	}
+}
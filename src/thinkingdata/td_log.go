@@ -1,7 +1,9 @@
 package thinkingdata
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -49,8 +51,120 @@ func SetCustomLogger(logger TDLogger) {
 	}
 }
 
-func tdLog(level TDLogLevel, format string, v ...interface{}) {
-	if level > currentLogLevel {
+// TDStructuredLogger is a leveled logger with key/value attributes. A TDLogger set by SetCustomLogger, SetLogger or
+// the Logger of the configs which also implements TDStructuredLogger receives the logs by Log instead of Print.
+// The message has no prefix and no trailing newline. The level set by SetLogLevel still applies.
+type TDStructuredLogger interface {
+	Log(level TDLogLevel, message string, attrs ...TDLogAttr)
+}
+
+// TDLogAttr key/value attribute of a log
+type TDLogAttr struct {
+	Key   string
+	Value interface{}
+}
+
+// keys of the attributes attached by the SDK
+const (
+	LogKeyAppId      = "appId"
+	LogKeyBatchSize  = "batchSize"
+	LogKeyStatusCode = "statusCode"
+	LogKeyCode       = "code"
+	LogKeyAttempt    = "attempt"
+	LogKeyFile       = "file"
+	LogKeyEventName  = "eventName"
+	LogKeyError      = "error"
+)
+
+func (l TDLogLevel) String() string {
+	switch l {
+	case TDLogLevelOff:
+		return "Off"
+	case TDLogLevelError:
+		return "Error"
+	case TDLogLevelWarning:
+		return "Warning"
+	case TDLogLevelInfo:
+		return "Info"
+	case TDLogLevelDebug:
+		return "Debug"
+	default:
+		return fmt.Sprintf("TDLogLevel(%d)", int32(l))
+	}
+}
+
+func logAttr(key string, value interface{}) TDLogAttr {
+	return TDLogAttr{Key: key, Value: value}
+}
+
+// receiverErrorAttrs the attributes of err if it's a ReceiverError, or the error itself.
+func receiverErrorAttrs(err error) []TDLogAttr {
+	var receiverErr *ReceiverError
+	if !errors.As(err, &receiverErr) {
+		return []TDLogAttr{logAttr(LogKeyError, err)}
+	}
+	return []TDLogAttr{
+		logAttr(LogKeyAppId, receiverErr.AppId),
+		logAttr(LogKeyBatchSize, receiverErr.BatchSize),
+		logAttr(LogKeyStatusCode, receiverErr.StatusCode),
+		logAttr(LogKeyCode, receiverErr.Code),
+		logAttr(LogKeyError, receiverErr.Err),
+	}
+}
+
+// instanceLogger is the logger of an SDK instance or a consumer. The package level logger and log level,
+// set by SetCustomLogger and SetLogLevel, are used when they are not set. A nil instanceLogger is valid.
+type instanceLogger struct {
+	logger TDLogger
+	level  TDLogLevel // 0 means the package level
+	attrs  []TDLogAttr
+}
+
+func newInstanceLogger(logger TDLogger, level TDLogLevel) *instanceLogger {
+	if level != 0 && (level < TDLogLevelOff || level > TDLogLevelDebug) {
+		fmt.Println(SDK_LOG_PREFIX + "log type error")
+		level = 0
+	}
+	return &instanceLogger{logger: logger, level: level}
+}
+
+// with a logger which attaches the attributes to the logs. The attributes are only passed to TDStructuredLogger,
+// the messages of the other loggers are not changed.
+func (l *instanceLogger) with(attrs ...TDLogAttr) *instanceLogger {
+	derived := &instanceLogger{}
+	if l != nil {
+		*derived = *l
+	}
+	derived.attrs = make([]TDLogAttr, 0, len(derived.attrs)+len(attrs))
+	if l != nil {
+		derived.attrs = append(derived.attrs, l.attrs...)
+	}
+	derived.attrs = append(derived.attrs, attrs...)
+	return derived
+}
+
+func (l *instanceLogger) enabled(level TDLogLevel) bool {
+	if l == nil || l.level == 0 {
+		return level <= currentLogLevel
+	}
+	return level <= l.level
+}
+
+func (l *instanceLogger) log(level TDLogLevel, format string, v ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	logger := logInstance
+	if l != nil && l.logger != nil {
+		logger = l.logger
+	}
+	if structured, ok := logger.(TDStructuredLogger); ok {
+		var attrs []TDLogAttr
+		if l != nil {
+			attrs = l.attrs
+		}
+		structured.Log(level, strings.TrimSuffix(fmt.Sprintf(format, v...), "\n"), attrs...)
 		return
 	}
 
@@ -73,29 +187,37 @@ func tdLog(level TDLogLevel, format string, v ...interface{}) {
 		break
 	}
 
-	if logInstance != nil {
+	if logger != nil {
 		msg := fmt.Sprintf(SDK_LOG_PREFIX+modeStr+format+"\n", v...)
-		logInstance.Print(msg)
+		logger.Print(msg)
 	} else {
 		logTime := fmt.Sprintf("[%v]", time.Now().Format("2006-01-02 15:04:05.000"))
 		fmt.Printf(logTime+SDK_LOG_PREFIX+modeStr+format+"\n", v...)
 	}
 }
 
-func tdLogDebug(format string, v ...interface{}) {
-	tdLog(TDLogLevelDebug, format, v...)
+func (l *instanceLogger) debug(format string, v ...interface{}) {
+	l.log(TDLogLevelDebug, format, v...)
 }
 
-func tdLogInfo(format string, v ...interface{}) {
-	tdLog(TDLogLevelInfo, format, v...)
+func (l *instanceLogger) info(format string, v ...interface{}) {
+	l.log(TDLogLevelInfo, format, v...)
+}
+
+func (l *instanceLogger) error(format string, v ...interface{}) {
+	l.log(TDLogLevelError, format, v...)
+}
+
+func (l *instanceLogger) warning(format string, v ...interface{}) {
+	l.log(TDLogLevelWarning, format, v...)
 }
 
-func tdLogError(format string, v ...interface{}) {
-	tdLog(TDLogLevelError, format, v...)
+func tdLog(level TDLogLevel, format string, v ...interface{}) {
+	(*instanceLogger)(nil).log(level, format, v...)
 }
 
-func tdLogWarning(format string, v ...interface{}) {
-	tdLog(TDLogLevelWarning, format, v...)
+func tdLogInfo(format string, v ...interface{}) {
+	tdLog(TDLogLevelInfo, format, v...)
 }
 
 // Deprecated: please use thinkingdata.SetLogLevel(thinkingdata.TDLogLevelOff)
@@ -1,8 +1,10 @@
 package thinkingdata
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"sync"
+	"time"
 )
 
 const (
@@ -22,20 +24,20 @@ const (
 )
 
 type Data struct {
-	IsComplex    bool                   `json:"-"` // properties are nested or not
-	AccountId    string                 `json:"#account_id,omitempty"`
-	DistinctId   string                 `json:"#distinct_id,omitempty"`
-	Type         string                 `json:"#type"`
-	Time         string                 `json:"#time"`
-	EventName    string                 `json:"#event_name,omitempty"`
-	EventId      string                 `json:"#event_id,omitempty"`
-	FirstCheckId string                 `json:"#first_check_id,omitempty"`
-	Ip           string                 `json:"#ip,omitempty"`
-	UUID         string                 `json:"#uuid,omitempty"`
-	AppId        string                 `json:"#app_id,omitempty"`
+	IsComplex           bool                   `json:"-"` // properties are nested or not
+	AccountId           string                 `json:"#account_id,omitempty"`
+	DistinctId          string                 `json:"#distinct_id,omitempty"`
+	Type                string                 `json:"#type"`
+	Time                string                 `json:"#time"`
+	EventName           string                 `json:"#event_name,omitempty"`
+	EventId             string                 `json:"#event_id,omitempty"`
+	FirstCheckId        string                 `json:"#first_check_id,omitempty"`
+	Ip                  string                 `json:"#ip,omitempty"`
+	UUID                string                 `json:"#uuid,omitempty"`
+	AppId               string                 `json:"#app_id,omitempty"`
 	TransactionProperty string                 `json:"#transaction_property,omitempty"`
 	ImportToolId        string                 `json:"#import_tool_id,omitempty"`
-	Properties   map[string]interface{} `json:"properties"`
+	Properties          map[string]interface{} `json:"properties"`
 }
 
 // TDConsumer define operation interface
@@ -46,11 +48,24 @@ type TDConsumer interface {
 	IsStringent() bool // check data or not.
 }
 
+// TDContextConsumer is implemented by consumers which honour cancellation and deadlines of a context.
+type TDContextConsumer interface {
+	TDConsumer
+	AddCtx(ctx context.Context, d Data) error
+	FlushCtx(ctx context.Context) error
+	CloseCtx(ctx context.Context) error
+}
+
 type TDAnalytics struct {
 	consumer               TDConsumer
 	superProperties        map[string]interface{}
 	mutex                  *sync.RWMutex
 	dynamicSuperProperties func() map[string]interface{}
+	interceptors           []TDInterceptor
+	samplers               []*sampler
+	location               *time.Location // times are reported in the location, nil to keep the location of each time
+	logger                 *instanceLogger
+	metrics                TDMetrics
 }
 
 // New init SDK
@@ -105,54 +120,142 @@ func (ta *TDAnalytics) GetDynamicSuperProperties() map[string]interface{} {
 	return result
 }
 
+// SetLogger set the logger of the SDK instance, nil to use the logger set by SetCustomLogger.
+func (ta *TDAnalytics) SetLogger(logger TDLogger) {
+	ta.mutex.Lock()
+	level := TDLogLevel(0)
+	if ta.logger != nil {
+		level = ta.logger.level
+	}
+	ta.logger = newInstanceLogger(logger, level)
+	ta.mutex.Unlock()
+}
+
+// SetLogLevel set the log level of the SDK instance, 0 to use the level set by the package level SetLogLevel.
+func (ta *TDAnalytics) SetLogLevel(level TDLogLevel) {
+	ta.mutex.Lock()
+	var logger TDLogger
+	if ta.logger != nil {
+		logger = ta.logger.logger
+	}
+	ta.logger = newInstanceLogger(logger, level)
+	ta.mutex.Unlock()
+}
+
+func (ta *TDAnalytics) log() *instanceLogger {
+	ta.mutex.RLock()
+	defer ta.mutex.RUnlock()
+	return ta.logger
+}
+
+// SetMetrics set the receiver of the metrics of the SDK instance, nil to discard them.
+// The consumers report their metrics by the Metrics of their configs.
+func (ta *TDAnalytics) SetMetrics(metrics TDMetrics) {
+	ta.mutex.Lock()
+	ta.metrics = metrics
+	ta.mutex.Unlock()
+}
+
+func (ta *TDAnalytics) getMetrics() TDMetrics {
+	ta.mutex.RLock()
+	defer ta.mutex.RUnlock()
+	return normalizeMetrics(ta.metrics)
+}
+
+// SetTimeLocation set the location which "#time" and time.Time properties are converted to, e.g. time.UTC.
+// "#zone_offset" is added to the events as well, the offset of the location in hours.
+// Nil keeps the location of each time, and the current time is in time.Local.
+func (ta *TDAnalytics) SetTimeLocation(loc *time.Location) {
+	ta.mutex.Lock()
+	ta.location = loc
+	ta.mutex.Unlock()
+}
+
+// GetTimeLocation get the location set by SetTimeLocation
+func (ta *TDAnalytics) GetTimeLocation() *time.Location {
+	ta.mutex.RLock()
+	defer ta.mutex.RUnlock()
+	return ta.location
+}
+
 // Track report ordinary event
 func (ta *TDAnalytics) Track(accountId, distinctId, eventName string, properties map[string]interface{}) error {
-	return ta.track(accountId, distinctId, Track, eventName, "", properties)
+	return ta.TrackCtx(context.Background(), accountId, distinctId, eventName, properties)
+}
+
+// TrackCtx report ordinary event, the context is passed down to the consumer.
+func (ta *TDAnalytics) TrackCtx(ctx context.Context, accountId, distinctId, eventName string, properties map[string]interface{}) error {
+	return ta.track(ctx, accountId, distinctId, Track, eventName, "", properties)
 }
 
 // TrackFirst report first event
 func (ta *TDAnalytics) TrackFirst(accountId, distinctId, eventName, firstCheckId string, properties map[string]interface{}) error {
+	return ta.TrackFirstCtx(context.Background(), accountId, distinctId, eventName, firstCheckId, properties)
+}
+
+// TrackFirstCtx report first event, the context is passed down to the consumer.
+func (ta *TDAnalytics) TrackFirstCtx(ctx context.Context, accountId, distinctId, eventName, firstCheckId string, properties map[string]interface{}) error {
 	if len(firstCheckId) == 0 {
-		msg := "the 'firstCheckId' must be provided"
-		tdLogInfo(msg)
-		return errors.New(msg)
+		ta.log().info(ErrEmptyFirstCheckId.Error())
+		return ErrEmptyFirstCheckId
 	}
 	p := make(map[string]interface{})
 	mergeProperties(p, properties)
 	p["#first_check_id"] = firstCheckId
-	return ta.track(accountId, distinctId, Track, eventName, "", p)
+	return ta.track(ctx, accountId, distinctId, Track, eventName, "", p)
 }
 
 // TrackUpdate report updatable event
 func (ta *TDAnalytics) TrackUpdate(accountId, distinctId, eventName, eventId string, properties map[string]interface{}) error {
-	return ta.track(accountId, distinctId, TrackUpdate, eventName, eventId, properties)
+	return ta.TrackUpdateCtx(context.Background(), accountId, distinctId, eventName, eventId, properties)
+}
+
+// TrackUpdateCtx report updatable event, the context is passed down to the consumer.
+func (ta *TDAnalytics) TrackUpdateCtx(ctx context.Context, accountId, distinctId, eventName, eventId string, properties map[string]interface{}) error {
+	return ta.track(ctx, accountId, distinctId, TrackUpdate, eventName, eventId, properties)
 }
 
 // TrackOverwrite report overridable event
 func (ta *TDAnalytics) TrackOverwrite(accountId, distinctId, eventName, eventId string, properties map[string]interface{}) error {
-	return ta.track(accountId, distinctId, TrackOverwrite, eventName, eventId, properties)
+	return ta.TrackOverwriteCtx(context.Background(), accountId, distinctId, eventName, eventId, properties)
 }
 
-func (ta *TDAnalytics) track(accountId, distinctId, dataType, eventName, eventId string, properties map[string]interface{}) error {
+// TrackOverwriteCtx report overridable event, the context is passed down to the consumer.
+func (ta *TDAnalytics) TrackOverwriteCtx(ctx context.Context, accountId, distinctId, eventName, eventId string, properties map[string]interface{}) error {
+	return ta.track(ctx, accountId, distinctId, TrackOverwrite, eventName, eventId, properties)
+}
+
+func (ta *TDAnalytics) track(ctx context.Context, accountId, distinctId, dataType, eventName, eventId string, properties map[string]interface{}) error {
 	defer func() {
 		if r := recover(); r != nil {
-			tdLogError("%+v\ndata: %+v", r, properties)
+			ta.log().error("%+v\ndata: %+v", r, properties)
 		}
 	}()
 
+	if err := ta.checkEvent(dataType, eventName, eventId); err != nil {
+		return err
+	}
+
+	p := ta.eventProperties(properties)
+	return ta.add(ctx, accountId, distinctId, dataType, eventName, eventId, p)
+}
+
+func (ta *TDAnalytics) checkEvent(dataType, eventName, eventId string) error {
 	if len(eventName) == 0 {
-		msg := "the event name must be provided"
-		tdLogError(msg)
-		return errors.New(msg)
+		ta.log().error(ErrEmptyEventName.Error())
+		return ErrEmptyEventName
 	}
 
 	// eventId not be null unless eventType is equal Track.
 	if len(eventId) == 0 && dataType != Track {
-		msg := "the event id must be provided"
-		tdLogError(msg)
-		return errors.New(msg)
+		ta.log().error(ErrEmptyEventId.Error())
+		return ErrEmptyEventId
 	}
+	return nil
+}
 
+// eventProperties merge the super properties, preset properties and custom properties of an event.
+func (ta *TDAnalytics) eventProperties(properties map[string]interface{}) map[string]interface{} {
 	p := ta.GetSuperProperties()
 	dynamicSuperProperties := ta.GetDynamicSuperProperties()
 
@@ -162,149 +265,280 @@ func (ta *TDAnalytics) track(accountId, distinctId, dataType, eventName, eventId
 	p["#lib_version"] = SdkVersion
 	// custom properties
 	mergeProperties(p, properties)
-
-	return ta.add(accountId, distinctId, dataType, eventName, eventId, p)
+	return p
 }
 
 // UserSet set user properties. would overwrite existing names.
 func (ta *TDAnalytics) UserSet(accountId string, distinctId string, properties map[string]interface{}) error {
-	return ta.user(accountId, distinctId, UserSet, properties)
+	return ta.UserSetCtx(context.Background(), accountId, distinctId, properties)
+}
+
+// UserSetCtx set user properties, the context is passed down to the consumer.
+func (ta *TDAnalytics) UserSetCtx(ctx context.Context, accountId string, distinctId string, properties map[string]interface{}) error {
+	return ta.user(ctx, accountId, distinctId, UserSet, properties)
 }
 
 // UserUnset clear the user properties of users.
 func (ta *TDAnalytics) UserUnset(accountId string, distinctId string, s []string) error {
+	return ta.UserUnsetCtx(context.Background(), accountId, distinctId, s)
+}
+
+// UserUnsetCtx clear the user properties of users, the context is passed down to the consumer.
+func (ta *TDAnalytics) UserUnsetCtx(ctx context.Context, accountId string, distinctId string, s []string) error {
 	if len(s) == 0 {
-		msg := "invalid params for UserUnset: keys is nil"
-		tdLogInfo(msg)
-		return errors.New(msg)
+		err := fmt.Errorf("%w for UserUnset: keys is nil", ErrInvalidParams)
+		ta.log().info(err.Error())
+		return err
 	}
 	prop := make(map[string]interface{})
 	for _, v := range s {
 		prop[v] = 0
 	}
-	return ta.user(accountId, distinctId, UserUnset, prop)
+	return ta.user(ctx, accountId, distinctId, UserUnset, prop)
 }
 
 func (ta *TDAnalytics) UserUnsetWithProperties(accountId string, distinctId string, properties map[string]interface{}) error {
+	return ta.UserUnsetWithPropertiesCtx(context.Background(), accountId, distinctId, properties)
+}
+
+func (ta *TDAnalytics) UserUnsetWithPropertiesCtx(ctx context.Context, accountId string, distinctId string, properties map[string]interface{}) error {
 	if len(properties) == 0 {
-		msg := "invalid params for UserUnset: properties is nil"
-		tdLogInfo(msg)
-		return errors.New(msg)
+		err := fmt.Errorf("%w for UserUnset: properties is nil", ErrInvalidParams)
+		ta.log().info(err.Error())
+		return err
 	}
-	return ta.user(accountId, distinctId, UserUnset, properties)
+	return ta.user(ctx, accountId, distinctId, UserUnset, properties)
 }
 
 // UserSetOnce set user properties, If such property had been set before, this message would be neglected.
 func (ta *TDAnalytics) UserSetOnce(accountId string, distinctId string, properties map[string]interface{}) error {
-	return ta.user(accountId, distinctId, UserSetOnce, properties)
+	return ta.UserSetOnceCtx(context.Background(), accountId, distinctId, properties)
+}
+
+// UserSetOnceCtx is UserSetOnce with a context passed down to the consumer.
+func (ta *TDAnalytics) UserSetOnceCtx(ctx context.Context, accountId string, distinctId string, properties map[string]interface{}) error {
+	return ta.user(ctx, accountId, distinctId, UserSetOnce, properties)
 }
 
 // UserAdd to accumulate operations against the property.
 func (ta *TDAnalytics) UserAdd(accountId string, distinctId string, properties map[string]interface{}) error {
-	return ta.user(accountId, distinctId, UserAdd, properties)
+	return ta.UserAddCtx(context.Background(), accountId, distinctId, properties)
+}
+
+// UserAddCtx is UserAdd with a context passed down to the consumer.
+func (ta *TDAnalytics) UserAddCtx(ctx context.Context, accountId string, distinctId string, properties map[string]interface{}) error {
+	return ta.user(ctx, accountId, distinctId, UserAdd, properties)
 }
 
 // UserAppend to add user properties of array type.
 func (ta *TDAnalytics) UserAppend(accountId string, distinctId string, properties map[string]interface{}) error {
-	return ta.user(accountId, distinctId, UserAppend, properties)
+	return ta.UserAppendCtx(context.Background(), accountId, distinctId, properties)
+}
+
+// UserAppendCtx is UserAppend with a context passed down to the consumer.
+func (ta *TDAnalytics) UserAppendCtx(ctx context.Context, accountId string, distinctId string, properties map[string]interface{}) error {
+	return ta.user(ctx, accountId, distinctId, UserAppend, properties)
 }
 
 // UserUniqAppend append user properties to array type by unique.
 func (ta *TDAnalytics) UserUniqAppend(accountId string, distinctId string, properties map[string]interface{}) error {
-	return ta.user(accountId, distinctId, UserUniqAppend, properties)
+	return ta.UserUniqAppendCtx(context.Background(), accountId, distinctId, properties)
+}
+
+// UserUniqAppendCtx is UserUniqAppend with a context passed down to the consumer.
+func (ta *TDAnalytics) UserUniqAppendCtx(ctx context.Context, accountId string, distinctId string, properties map[string]interface{}) error {
+	return ta.user(ctx, accountId, distinctId, UserUniqAppend, properties)
 }
 
 // UserDelete delete a user, This operation cannot be undone.
 func (ta *TDAnalytics) UserDelete(accountId string, distinctId string) error {
-	return ta.user(accountId, distinctId, UserDel, nil)
+	return ta.UserDeleteCtx(context.Background(), accountId, distinctId)
+}
+
+// UserDeleteCtx is UserDelete with a context passed down to the consumer.
+func (ta *TDAnalytics) UserDeleteCtx(ctx context.Context, accountId string, distinctId string) error {
+	return ta.user(ctx, accountId, distinctId, UserDel, nil)
 }
 
 // UserDeleteWithProperties delete a user, This operation cannot be undone.
 func (ta *TDAnalytics) UserDeleteWithProperties(accountId string, distinctId string, properties map[string]interface{}) error {
-	return ta.user(accountId, distinctId, UserDel, properties)
+	return ta.UserDeleteWithPropertiesCtx(context.Background(), accountId, distinctId, properties)
+}
+
+// UserDeleteWithPropertiesCtx is UserDeleteWithProperties with a context passed down to the consumer.
+func (ta *TDAnalytics) UserDeleteWithPropertiesCtx(ctx context.Context, accountId string, distinctId string, properties map[string]interface{}) error {
+	return ta.user(ctx, accountId, distinctId, UserDel, properties)
 }
 
-func (ta *TDAnalytics) user(accountId, distinctId, dataType string, properties map[string]interface{}) error {
+func (ta *TDAnalytics) user(ctx context.Context, accountId, distinctId, dataType string, properties map[string]interface{}) error {
 	defer func() {
 		if r := recover(); r != nil {
-			tdLogError("%+v\ndata: %+v", r, properties)
+			ta.log().error("%+v\ndata: %+v", r, properties)
 		}
 	}()
 	if properties == nil && dataType != UserDel {
-		msg := "invalid params for " + dataType + ": properties is nil"
-		tdLogError(msg)
-		return errors.New(msg)
+		err := fmt.Errorf("%w for %s: properties is nil", ErrInvalidParams, dataType)
+		ta.log().error(err.Error())
+		return err
 	}
 	p := make(map[string]interface{})
 	mergeProperties(p, properties)
-	return ta.add(accountId, distinctId, dataType, "", "", p)
+	return ta.add(ctx, accountId, distinctId, dataType, "", "", p)
 }
 
 // Flush report data immediately.
 func (ta *TDAnalytics) Flush() error {
-	return ta.consumer.Flush()
+	return ta.FlushCtx(context.Background())
+}
+
+// FlushCtx report data immediately, uploading stops when the context is done.
+func (ta *TDAnalytics) FlushCtx(ctx context.Context) error {
+	return flushConsumer(ctx, ta.consumer)
 }
 
 // Close and exit sdk
 func (ta *TDAnalytics) Close() error {
-	err := ta.consumer.Close()
-	tdLogInfo("SDK close")
+	return ta.CloseCtx(context.Background())
+}
+
+// CloseCtx close and exit sdk, the remaining data is flushed until the context is done.
+func (ta *TDAnalytics) CloseCtx(ctx context.Context) error {
+	err := closeConsumer(ctx, ta.consumer)
+	ta.log().info("SDK close")
 	return err
 }
 
-func (ta *TDAnalytics) add(accountId, distinctId, dataType, eventName, eventId string, properties map[string]interface{}) error {
-	if len(accountId) == 0 && len(distinctId) == 0 {
-		msg := "invalid parameters: account_id and distinct_id cannot be empty at the same time"
-		tdLogError(msg)
-		return errors.New(msg)
-	}
+func (ta *TDAnalytics) add(ctx context.Context, accountId, distinctId, dataType, eventName, eventId string, properties map[string]interface{}) error {
+	data := ta.newData(accountId, distinctId, dataType, eventName, eventId, properties)
+	return ta.dispatch(ctx, data)
+}
+
+// newData create data, the preset properties are moved from properties to the fields of Data.
+// "#zone_offset" of events is derived from the event time if a location is set by SetTimeLocation, unless it's set in properties.
+func (ta *TDAnalytics) newData(accountId, distinctId, dataType, eventName, eventId string, properties map[string]interface{}) Data {
+	logger := ta.log()
 
 	// get "#ip" value in properties, empty string will be return when not found.
-	ip := extractStringProperty(properties, "#ip")
+	ip := extractStringProperty(properties, "#ip", logger)
 
 	// get "#app_id" value in properties, empty string will be return when not found.
-	appId := extractStringProperty(properties, "#app_id")
+	appId := extractStringProperty(properties, "#app_id", logger)
 
 	// get "#time" value in properties, empty string will be return when not found.
-	eventTime := extractTime(properties)
+	loc := ta.GetTimeLocation()
+	eventTime, t := extractTime(properties, loc)
 
-	firstCheckId := extractStringProperty(properties, "#first_check_id")
+	firstCheckId := extractStringProperty(properties, "#first_check_id", logger)
 
-	transactionProperty := extractStringProperty(properties, "#transaction_property")
+	transactionProperty := extractStringProperty(properties, "#transaction_property", logger)
 
-	importToolId := extractStringProperty(properties, "#import_tool_id")
+	importToolId := extractStringProperty(properties, "#import_tool_id", logger)
 
 	// get "#uuid" value in properties, empty string will be return when not found.
-	uuid := extractStringProperty(properties, "#uuid")
+	uuid := extractStringProperty(properties, "#uuid", logger)
 	if len(uuid) == 0 {
 		uuid = generateUUID()
 	}
 
 	data := Data{
-		AccountId:    accountId,
-		DistinctId:   distinctId,
-		Type:         dataType,
-		Time:         eventTime,
-		EventName:    eventName,
-		EventId:      eventId,
-		FirstCheckId: firstCheckId,
-		Ip:           ip,
-		UUID:         uuid,
+		AccountId:           accountId,
+		DistinctId:          distinctId,
+		Type:                dataType,
+		Time:                eventTime,
+		EventName:           eventName,
+		EventId:             eventId,
+		FirstCheckId:        firstCheckId,
+		Ip:                  ip,
+		UUID:                uuid,
 		TransactionProperty: transactionProperty,
 		ImportToolId:        importToolId,
-		Properties:   properties,
+		Properties:          properties,
 	}
 
 	if len(appId) > 0 {
 		data.AppId = appId
 	}
+	if loc != nil && !t.IsZero() {
+		setZoneOffset(&data, t)
+	}
+	return data
+}
 
-	err := formatProperties(&data, ta)
+// setZoneOffset set "#zone_offset" of an event, the offset of the event time from UTC in hours.
+func setZoneOffset(d *Data, t time.Time) {
+	if d.Type != Track && d.Type != TrackUpdate && d.Type != TrackOverwrite {
+		return
+	}
+	if _, ok := d.Properties["#zone_offset"]; ok || d.Properties == nil {
+		return
+	}
+	_, offset := t.Zone()
+	d.Properties["#zone_offset"] = float64(offset) / 3600
+}
+
+// dispatch check the data and hand it over to the consumer.
+func (ta *TDAnalytics) dispatch(ctx context.Context, data Data) error {
+	metrics := ta.getMetrics()
+	metrics.Counter(MetricEventsTracked, 1, metricLabel(MetricLabelType, data.Type))
+
+	keep, err := ta.sample(&data)
+	if !keep {
+		reason := DropReasonSampled
+		if err != nil {
+			reason = DropReasonRateLimited
+		}
+		metrics.Counter(MetricEventsDropped, 1, metricLabel(MetricLabelReason, reason))
+		return err
+	}
+
+	d, err := ta.intercept(&data)
 	if err != nil {
+		metrics.Counter(MetricEventsDropped, 1, metricLabel(MetricLabelReason, DropReasonInterceptor))
 		return err
 	}
+	data = *d
 
-	return ta.consumer.Add(data)
+	if len(data.AccountId) == 0 && len(data.DistinctId) == 0 {
+		ta.log().error(ErrEmptyUserId.Error())
+		metrics.Counter(MetricEventsRejected, 1, metricLabel(MetricLabelType, data.Type))
+		return ErrEmptyUserId
+	}
+
+	err = formatProperties(&data, ta)
+	if err != nil {
+		metrics.Counter(MetricEventsRejected, 1, metricLabel(MetricLabelType, data.Type))
+		return err
+	}
+
+	return addToConsumer(ctx, ta.consumer, data)
+}
+
+// addToConsumer call AddCtx if the consumer supports context, otherwise Add is called when the context is not done.
+func addToConsumer(ctx context.Context, c TDConsumer, d Data) error {
+	if cc, ok := c.(TDContextConsumer); ok {
+		return cc.AddCtx(ctx, d)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Add(d)
+}
+
+func flushConsumer(ctx context.Context, c TDConsumer) error {
+	if cc, ok := c.(TDContextConsumer); ok {
+		return cc.FlushCtx(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+func closeConsumer(ctx context.Context, c TDConsumer) error {
+	if cc, ok := c.(TDContextConsumer); ok {
+		return cc.CloseCtx(ctx)
+	}
+	return c.Close()
 }
 
 // Deprecated: please use TDConsumer
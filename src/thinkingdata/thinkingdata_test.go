@@ -0,0 +1,103 @@
+package thinkingdata_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata/thinkingdatatest"
+)
+
+func TestTimeLocation(t *testing.T) {
+	c := thinkingdatatest.NewRecordingConsumer()
+	ta := thinkingdata.New(c)
+	eventTime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// the payload is not changed without a location
+	if err := ta.Track("A1", "", "before", map[string]interface{}{"#time": eventTime}); err != nil {
+		t.Fatal(err)
+	}
+	d := thinkingdatatest.AssertEvent(t, c, "before")
+	thinkingdatatest.AssertNoProperty(t, d, "#zone_offset")
+	if d.Time != "2023-01-02 03:04:05.000" {
+		t.Errorf("got time %s", d.Time)
+	}
+
+	ta.SetTimeLocation(time.FixedZone("UTC+8", 8*3600))
+	properties := map[string]interface{}{"#time": eventTime, "at": eventTime}
+	if err := ta.Track("A1", "", "after", properties); err != nil {
+		t.Fatal(err)
+	}
+	d = thinkingdatatest.AssertEvent(t, c, "after")
+	thinkingdatatest.AssertProperty(t, d, "#zone_offset", 8)
+	if d.Time != "2023-01-02 11:04:05.000" {
+		t.Errorf("got time %s", d.Time)
+	}
+	thinkingdatatest.AssertProperty(t, d, "at", "2023-01-02 11:04:05.000")
+
+	// user properties have no "#zone_offset"
+	if err := ta.UserSet("A1", "", map[string]interface{}{"level": 1}); err != nil {
+		t.Fatal(err)
+	}
+	d = thinkingdatatest.AssertCount(t, c, 1, thinkingdatatest.ByType(thinkingdata.UserSet))[0]
+	thinkingdatatest.AssertNoProperty(t, d, "#zone_offset")
+}
+
+type structBase struct {
+	Channel string `td:"channel"`
+	Level   int    `td:"level"`
+}
+
+type structOrder struct {
+	structBase
+	Level   int       `td:"order_level"`
+	Price   float64   `td:"price"`
+	Coupon  string    `td:"coupon,omitempty"`
+	Secret  string    `td:"-"`
+	Items   []string  `td:"items"`
+	PaidAt  time.Time `td:"paid_at,omitempty"`
+	Comment string
+}
+
+func TestStructTags(t *testing.T) {
+	c := thinkingdatatest.NewRecordingConsumer()
+	ta := thinkingdata.New(c)
+	order := &structOrder{
+		structBase: structBase{Channel: "store", Level: 1},
+		Level:      2,
+		Price:      9.9,
+		Secret:     "secret",
+		Items:      []string{"a", "b"},
+		Comment:    "fast",
+	}
+
+	if err := ta.TrackStruct("A1", "", "purchase", order); err != nil {
+		t.Fatal(err)
+	}
+	d := thinkingdatatest.AssertEvent(t, c, "purchase")
+	thinkingdatatest.AssertProperties(t, d, map[string]interface{}{
+		"channel":     "store",
+		"level":       1,
+		"order_level": 2,
+		"price":       9.9,
+		"items":       []interface{}{"a", "b"},
+		"Comment":     "fast",
+	})
+	for _, key := range []string{"coupon", "Secret", "paid_at"} {
+		thinkingdatatest.AssertNoProperty(t, d, key)
+	}
+
+	order.Coupon = "C1"
+	order.PaidAt = time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := ta.UserSetStruct("A1", "", order); err != nil {
+		t.Fatal(err)
+	}
+	d = thinkingdatatest.AssertCount(t, c, 1, thinkingdatatest.ByType(thinkingdata.UserSet))[0]
+	thinkingdatatest.AssertProperty(t, d, "coupon", "C1")
+	thinkingdatatest.AssertProperty(t, d, "paid_at", "2023-01-02 03:04:05.000")
+
+	if err := ta.TrackStruct("A1", "", "purchase", map[string]interface{}{}); !errors.Is(err, thinkingdata.ErrInvalidParams) {
+		t.Errorf("got %v, want ErrInvalidParams", err)
+	}
+}
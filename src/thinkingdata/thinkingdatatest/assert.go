@@ -0,0 +1,124 @@
+package thinkingdatatest
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+)
+
+// AssertCount check the count of the recorded data which matches the filters, and return the data.
+func AssertCount(t testing.TB, c *RecordingConsumer, want int, filters ...Filter) []thinkingdata.Data {
+	t.Helper()
+	data := c.Find(filters...)
+	if len(data) != want {
+		t.Fatalf("thinkingdatatest: got %d data, want %d", len(data), want)
+	}
+	return data
+}
+
+// AssertEvent check that exactly one event of the name is recorded, and return it.
+func AssertEvent(t testing.TB, c *RecordingConsumer, eventName string, filters ...Filter) thinkingdata.Data {
+	t.Helper()
+	data := c.Find(append([]Filter{ByEventName(eventName)}, filters...)...)
+	if len(data) != 1 {
+		t.Fatalf("thinkingdatatest: got %d event(s) %q, want 1", len(data), eventName)
+	}
+	return data[0]
+}
+
+// AssertNoEvent check that no event of the name is recorded.
+func AssertNoEvent(t testing.TB, c *RecordingConsumer, eventName string, filters ...Filter) {
+	t.Helper()
+	data := c.Find(append([]Filter{ByEventName(eventName)}, filters...)...)
+	if len(data) != 0 {
+		t.Fatalf("thinkingdatatest: got %d event(s) %q, want none", len(data), eventName)
+	}
+}
+
+// AssertUser check the user ids of the data.
+func AssertUser(t testing.TB, d thinkingdata.Data, accountId, distinctId string) {
+	t.Helper()
+	if d.AccountId != accountId || d.DistinctId != distinctId {
+		t.Fatalf("thinkingdatatest: got user (%q, %q), want (%q, %q)", d.AccountId, d.DistinctId, accountId, distinctId)
+	}
+}
+
+// AssertProperty check a property value of the data. Numbers are compared by value regardless of the type,
+// e.g. int 1 equals float64 1.
+func AssertProperty(t testing.TB, d thinkingdata.Data, key string, want interface{}) {
+	t.Helper()
+	got, ok := d.Properties[key]
+	if !ok {
+		t.Fatalf("thinkingdatatest: property %q not found in %v", key, d.Properties)
+	}
+	if !valueEqual(got, want) {
+		t.Fatalf("thinkingdatatest: property %q = %#v, want %#v", key, got, want)
+	}
+}
+
+// AssertProperties check the property values of the data, other properties are ignored.
+func AssertProperties(t testing.TB, d thinkingdata.Data, want map[string]interface{}) {
+	t.Helper()
+	for _, key := range sortedKeys(want) {
+		AssertProperty(t, d, key, want[key])
+	}
+}
+
+// AssertNoProperty check that the data doesn't have the property.
+func AssertNoProperty(t testing.TB, d thinkingdata.Data, key string) {
+	t.Helper()
+	if v, ok := d.Properties[key]; ok {
+		t.Fatalf("thinkingdatatest: unexpected property %q = %#v", key, v)
+	}
+}
+
+// AssertMerged check the result of merging the super properties and the properties of the event,
+// the properties of the event take precedence over the super properties.
+func AssertMerged(t testing.TB, d thinkingdata.Data, superProperties, properties map[string]interface{}) {
+	t.Helper()
+	merged := make(map[string]interface{}, len(superProperties)+len(properties))
+	for k, v := range superProperties {
+		merged[k] = v
+	}
+	for k, v := range properties {
+		merged[k] = v
+	}
+	AssertProperties(t, d, merged)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func valueEqual(got, want interface{}) bool {
+	if g, ok := toFloat(got); ok {
+		w, ok := toFloat(want)
+		return ok && g == w
+	}
+	if g, ok := got.(time.Time); ok {
+		w, ok := want.(time.Time)
+		return ok && g.Equal(w)
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,316 @@
+package thinkingdatatest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+)
+
+// Receiver is a fake ThinkingData receiver which serves /sync_server for TDBatchConsumer and /data_debug for TDDebugConsumer.
+//
+//	r := thinkingdatatest.NewReceiver()
+//	defer r.Close()
+//	consumer, _ := thinkingdata.NewBatchConsumer(r.URL, "appId")
+//
+// Requests which break the protocol, e.g. missing headers, are answered with 400 and recorded with an error.
+type Receiver struct {
+	URL    string // base url of the receiver, used as ServerUrl of the consumers
+	server *httptest.Server
+
+	mutex      sync.Mutex
+	appIds     map[string]bool // accepted app ids, any app id is accepted when empty
+	code       int             // code in the response of /sync_server
+	errorLevel int             // errorLevel in the response of /data_debug
+	latency    time.Duration
+	failCount  int // count of the next requests answered with failStatus
+	failStatus int
+	reject     func(d thinkingdata.Data) bool // events rejected as invalid data format
+	requests   []ReceivedRequest
+}
+
+// ReceivedRequest a request received by Receiver
+type ReceivedRequest struct {
+	Path     string              // "/sync_server" or "/data_debug"
+	AppId    string              // app id of the request
+	Compress string              // "gzip" or "none", only for /sync_server
+	Count    int                 // TA-Integration-Count header, only for /sync_server
+	DryRun   bool                // dryRun of /data_debug, the events are not archived
+	DeviceId string              // deviceId of /data_debug
+	Status   int                 // http status code of the response
+	Code     int                 // code answered by /sync_server
+	ErrLevel int                 // errorLevel answered by /data_debug
+	Events   []thinkingdata.Data // events in the request
+	Err      error               // protocol error of the request
+}
+
+// NewReceiver start a Receiver which accepts all the data, call Close to stop it.
+func NewReceiver() *Receiver {
+	r := &Receiver{appIds: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync_server", r.handleSync)
+	mux.HandleFunc("/data_debug", r.handleDebug)
+	r.server = httptest.NewServer(mux)
+	r.URL = r.server.URL
+	return r
+}
+
+// Close stop the receiver
+func (r *Receiver) Close() {
+	r.server.Close()
+}
+
+// SetAppIds set the accepted app ids, the data of other app ids is answered with code -2.
+func (r *Receiver) SetAppIds(appIds ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.appIds = make(map[string]bool, len(appIds))
+	for _, appId := range appIds {
+		r.appIds[appId] = true
+	}
+}
+
+// SetCode set the code answered by /sync_server, e.g. -1 for invalid data format.
+func (r *Receiver) SetCode(code int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.code = code
+}
+
+// RejectIf answer the requests of /sync_server which contain any event matching the function with code -1,
+// i.e. invalid data format. Pass nil to accept all the events again.
+func (r *Receiver) RejectIf(reject func(d thinkingdata.Data) bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reject = reject
+}
+
+// SetErrorLevel set the errorLevel answered by /data_debug, 0 means success.
+func (r *Receiver) SetErrorLevel(errorLevel int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.errorLevel = errorLevel
+}
+
+// SetLatency delay every response
+func (r *Receiver) SetLatency(latency time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.latency = latency
+}
+
+// FailNext answer the next n requests with the http status code, e.g. http.StatusServiceUnavailable.
+func (r *Receiver) FailNext(n int, status int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failCount = n
+	r.failStatus = status
+}
+
+// Requests get all the received requests in the order of receiving.
+func (r *Receiver) Requests() []ReceivedRequest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	requests := make([]ReceivedRequest, len(r.requests))
+	copy(requests, r.requests)
+	return requests
+}
+
+// Events get the events which are accepted and archived by the receiver.
+func (r *Receiver) Events() []thinkingdata.Data {
+	var events []thinkingdata.Data
+	for _, req := range r.Requests() {
+		if req.Status == http.StatusOK && req.Err == nil && !req.DryRun && req.accepted() {
+			events = append(events, req.Events...)
+		}
+	}
+	return events
+}
+
+// Reset remove the received requests, the settings are kept.
+func (r *Receiver) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = nil
+}
+
+// accepted report whether the events are archived, i.e. code or errorLevel is 0.
+func (req ReceivedRequest) accepted() bool {
+	return req.Code == 0 && req.ErrLevel == 0
+}
+
+func (r *Receiver) handleSync(w http.ResponseWriter, httpReq *http.Request) {
+	req := ReceivedRequest{
+		Path:     httpReq.URL.Path,
+		AppId:    httpReq.Header.Get("appid"),
+		Compress: httpReq.Header.Get("compress"),
+	}
+	req.Events, req.Count, req.Err = readSyncBody(httpReq)
+
+	status, code := r.answer(httpReq, &req)
+	if status == http.StatusOK && code == 0 && r.rejects(req.Events) {
+		code = -1
+	}
+	req.Code = code
+	r.respond(w, &req, status, map[string]interface{}{"code": code})
+}
+
+func (r *Receiver) handleDebug(w http.ResponseWriter, httpReq *http.Request) {
+	req := ReceivedRequest{Path: httpReq.URL.Path}
+	req.Err = httpReq.ParseForm()
+	if req.Err == nil {
+		req.AppId = httpReq.PostForm.Get("appid")
+		req.DryRun = httpReq.PostForm.Get("dryRun") == "1"
+		req.DeviceId = httpReq.PostForm.Get("deviceId")
+		req.Events, req.Err = readDebugForm(httpReq)
+	}
+
+	status, code := r.answer(httpReq, &req)
+	r.mutex.Lock()
+	errorLevel := r.errorLevel
+	r.mutex.Unlock()
+	if code == -2 && errorLevel == 0 {
+		// unknown app id
+		errorLevel = 2
+	}
+	req.ErrLevel = errorLevel
+
+	result := map[string]interface{}{"errorLevel": errorLevel}
+	if errorLevel != 0 {
+		result["errorReasons"] = []string{fmt.Sprintf("errorLevel %d", errorLevel)}
+	}
+	r.respond(w, &req, status, result)
+}
+
+// answer decide the http status and the code of a request, the latency is applied here.
+func (r *Receiver) answer(httpReq *http.Request, req *ReceivedRequest) (status int, code int) {
+	r.mutex.Lock()
+	latency := r.latency
+	failing := r.failCount > 0
+	if failing {
+		r.failCount--
+	}
+	failStatus := r.failStatus
+	code = r.code
+	if len(r.appIds) > 0 && !r.appIds[req.AppId] {
+		code = -2
+	}
+	r.mutex.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-httpReq.Context().Done():
+		}
+	}
+
+	switch {
+	case req.Err != nil:
+		return http.StatusBadRequest, 0
+	case failing:
+		return failStatus, 0
+	case httpReq.Method != http.MethodPost:
+		req.Err = fmt.Errorf("unexpected method %s", httpReq.Method)
+		return http.StatusMethodNotAllowed, 0
+	case len(req.AppId) == 0:
+		req.Err = fmt.Errorf("missing appid")
+		return http.StatusBadRequest, 0
+	}
+	return http.StatusOK, code
+}
+
+// rejects report whether any of the events is rejected by the function of RejectIf.
+func (r *Receiver) rejects(events []thinkingdata.Data) bool {
+	r.mutex.Lock()
+	reject := r.reject
+	r.mutex.Unlock()
+	if reject == nil {
+		return false
+	}
+	for _, d := range events {
+		if reject(d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Receiver) respond(w http.ResponseWriter, req *ReceivedRequest, status int, result map[string]interface{}) {
+	req.Status = status
+	r.mutex.Lock()
+	r.requests = append(r.requests, *req)
+	r.mutex.Unlock()
+
+	if status != http.StatusOK {
+		msg := http.StatusText(status)
+		if req.Err != nil {
+			msg = req.Err.Error()
+		}
+		http.Error(w, msg, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// readSyncBody check the headers set by TDBatchConsumer and decode the events.
+func readSyncBody(httpReq *http.Request) ([]thinkingdata.Data, int, error) {
+	for _, header := range []string{"appid", "user-agent", "version", "compress", "TA-Integration-Type", "TA-Integration-Version", "TA-Integration-Count"} {
+		if len(httpReq.Header.Get(header)) == 0 {
+			return nil, 0, fmt.Errorf("missing header %s", header)
+		}
+	}
+	count, err := strconv.Atoi(httpReq.Header.Get("TA-Integration-Count"))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid TA-Integration-Count: %s", err)
+	}
+
+	var body []byte
+	switch compress := httpReq.Header.Get("compress"); compress {
+	case "gzip":
+		gr, err := gzip.NewReader(httpReq.Body)
+		if err != nil {
+			return nil, count, fmt.Errorf("invalid gzip body: %s", err)
+		}
+		defer gr.Close()
+		body, err = ioutil.ReadAll(gr)
+		if err != nil {
+			return nil, count, fmt.Errorf("invalid gzip body: %s", err)
+		}
+	case "none":
+		body, err = ioutil.ReadAll(httpReq.Body)
+		if err != nil {
+			return nil, count, err
+		}
+	default:
+		return nil, count, fmt.Errorf("unknown compress %s", compress)
+	}
+
+	var events []thinkingdata.Data
+	if err := json.Unmarshal(body, &events); err != nil {
+		return nil, count, fmt.Errorf("invalid data: %s", err)
+	}
+	if len(events) != count {
+		return events, count, fmt.Errorf("TA-Integration-Count is %d, but %d events are received", count, len(events))
+	}
+	return events, count, nil
+}
+
+// readDebugForm check the form fields set by TDDebugConsumer and decode the event.
+func readDebugForm(httpReq *http.Request) ([]thinkingdata.Data, error) {
+	if source := httpReq.PostForm.Get("source"); source != "server" {
+		return nil, fmt.Errorf("unexpected source %q", source)
+	}
+	var d thinkingdata.Data
+	if err := json.Unmarshal([]byte(httpReq.PostForm.Get("data")), &d); err != nil {
+		return nil, fmt.Errorf("invalid data: %s", err)
+	}
+	return []thinkingdata.Data{d}, nil
+}
@@ -0,0 +1,164 @@
+package thinkingdatatest_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata/thinkingdatatest"
+)
+
+func newBatchConsumer(t *testing.T, r *thinkingdatatest.Receiver, compress bool) thinkingdata.TDConsumer {
+	t.Helper()
+	c, err := thinkingdata.NewBatchConsumerWithConfig(thinkingdata.TDBatchConfig{
+		ServerUrl:   r.URL,
+		AppId:       "app",
+		Compress:    compress,
+		BatchSize:   10,
+		RetryPolicy: &thinkingdata.TDRetryPolicy{MaxAttempts: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReceiverSync(t *testing.T) {
+	for _, compress := range []bool{true, false} {
+		r := thinkingdatatest.NewReceiver()
+		c := newBatchConsumer(t, r, compress)
+		ta := thinkingdata.New(c)
+		ta.Track("A1", "", "purchase", map[string]interface{}{"price": 9.9})
+		ta.Track("A1", "", "login", nil)
+		if err := ta.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		requests := r.Requests()
+		if len(requests) != 1 {
+			t.Fatalf("got %d requests, want 1", len(requests))
+		}
+		req := requests[0]
+		want := "none"
+		if compress {
+			want = "gzip"
+		}
+		if req.Path != "/sync_server" || req.AppId != "app" || req.Compress != want || req.Count != 2 || req.Err != nil {
+			t.Errorf("got request %+v", req)
+		}
+		events := r.Events()
+		if len(events) != 2 || events[0].EventName != "purchase" || events[0].Properties["price"] != 9.9 {
+			t.Errorf("got events %+v", events)
+		}
+
+		r.Reset()
+		if len(r.Requests()) != 0 {
+			t.Error("the requests are not removed")
+		}
+		ta.Close()
+		r.Close()
+	}
+}
+
+func TestReceiverAppIds(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.SetAppIds("other")
+	ta := thinkingdata.New(newBatchConsumer(t, r, true))
+	defer ta.Close()
+
+	ta.Track("A1", "", "purchase", nil)
+	err := ta.Flush()
+	var receiverErr *thinkingdata.ReceiverError
+	if !errors.As(err, &receiverErr) || receiverErr.Code != -2 {
+		t.Fatalf("got %v, want code -2", err)
+	}
+	if len(r.Events()) != 0 {
+		t.Error("the events of an unknown app id are archived")
+	}
+}
+
+func TestReceiverFailNext(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.FailNext(1, http.StatusServiceUnavailable)
+	ta := thinkingdata.New(newBatchConsumer(t, r, true))
+	defer ta.Close()
+
+	ta.Track("A1", "", "purchase", nil)
+	if err := ta.Flush(); !errors.Is(err, thinkingdata.ErrUnexpectedStatus) {
+		t.Fatalf("got %v, want ErrUnexpectedStatus", err)
+	}
+	// the batch is kept and uploaded again
+	if err := ta.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	requests := r.Requests()
+	if len(requests) != 2 || requests[0].Status != http.StatusServiceUnavailable || requests[1].Status != http.StatusOK {
+		t.Fatalf("got requests %+v", requests)
+	}
+	if len(r.Events()) != 1 {
+		t.Errorf("got %d events, want 1", len(r.Events()))
+	}
+}
+
+func TestReceiverRejectIf(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	r.RejectIf(func(d thinkingdata.Data) bool { return d.EventName == "invalid" })
+	ta := thinkingdata.New(newBatchConsumer(t, r, true))
+	defer ta.Close()
+
+	ta.Track("A1", "", "purchase", nil)
+	ta.Track("A1", "", "invalid", nil)
+	if err := ta.Flush(); !errors.Is(err, thinkingdata.ErrInvalidDataFormat) {
+		t.Fatalf("got %v, want ErrInvalidDataFormat", err)
+	}
+
+	r.RejectIf(nil)
+	ta.Track("A1", "", "invalid", nil)
+	if err := ta.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if events := r.Events(); len(events) != 1 || events[0].EventName != "invalid" {
+		t.Errorf("got events %+v", events)
+	}
+}
+
+func TestReceiverDebug(t *testing.T) {
+	r := thinkingdatatest.NewReceiver()
+	defer r.Close()
+	c, err := thinkingdata.NewDebugConsumerWithConfig(thinkingdata.TDDebugConfig{
+		ServerUrl: r.URL,
+		AppId:     "app",
+		DryRun:    true,
+		DeviceId:  "device",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := thinkingdata.New(c)
+	defer ta.Close()
+
+	if err := ta.Track("A1", "", "purchase", nil); err != nil {
+		t.Fatal(err)
+	}
+	requests := r.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if req.Path != "/data_debug" || req.AppId != "app" || !req.DryRun || req.DeviceId != "device" || len(req.Events) != 1 {
+		t.Errorf("got request %+v", req)
+	}
+	// the events of dry run are not archived
+	if len(r.Events()) != 0 {
+		t.Errorf("got %d events, want 0", len(r.Events()))
+	}
+
+	r.SetErrorLevel(1)
+	if err := ta.Track("A1", "", "purchase", nil); !errors.Is(err, thinkingdata.ErrInvalidDataFormat) {
+		t.Fatalf("got %v, want ErrInvalidDataFormat", err)
+	}
+}
@@ -0,0 +1,200 @@
+// Package thinkingdatatest provides a recording consumer and assertion helpers to test the code which reports data by TDAnalytics.
+//
+//	c := thinkingdatatest.NewRecordingConsumer()
+//	ta := thinkingdata.New(c)
+//	// ... call the code under test
+//	d := thinkingdatatest.AssertEvent(t, c, "purchase")
+//	thinkingdatatest.AssertProperty(t, d, "price", 9.9)
+package thinkingdatatest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+)
+
+// RecordingConsumer keep every data in memory instead of reporting it. It is safe for concurrent use.
+type RecordingConsumer struct {
+	mutex      sync.RWMutex
+	data       []thinkingdata.Data
+	stringent  bool
+	flushCount int
+	closed     bool
+	err        error
+}
+
+// NewRecordingConsumer create RecordingConsumer, the data is not checked strictly.
+func NewRecordingConsumer() *RecordingConsumer {
+	return &RecordingConsumer{}
+}
+
+// NewStringentRecordingConsumer create RecordingConsumer which requires the data to be checked strictly, like TDDebugConsumer.
+func NewStringentRecordingConsumer() *RecordingConsumer {
+	return &RecordingConsumer{stringent: true}
+}
+
+func (c *RecordingConsumer) Add(d thinkingdata.Data) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return fmt.Errorf("%w: add data", thinkingdata.ErrConsumerClosed)
+	}
+	if c.err != nil {
+		return c.err
+	}
+	// the properties are copied, so that later changes of the caller are not recorded
+	if d.Properties != nil {
+		p := make(map[string]interface{}, len(d.Properties))
+		for k, v := range d.Properties {
+			p[k] = v
+		}
+		d.Properties = p
+	}
+	c.data = append(c.data, d)
+	return nil
+}
+
+func (c *RecordingConsumer) Flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.flushCount++
+	return nil
+}
+
+func (c *RecordingConsumer) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *RecordingConsumer) IsStringent() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.stringent
+}
+
+// SetStringent set whether the data is checked strictly by TDAnalytics, e.g. the format of property keys.
+func (c *RecordingConsumer) SetStringent(stringent bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stringent = stringent
+}
+
+// SetError make Add return err instead of recording the data, nil to record again.
+func (c *RecordingConsumer) SetError(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.err = err
+}
+
+// Data get all the recorded data in the order of adding.
+func (c *RecordingConsumer) Data() []thinkingdata.Data {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	data := make([]thinkingdata.Data, len(c.data))
+	copy(data, c.data)
+	return data
+}
+
+// Find get the recorded data which matches all the filters.
+func (c *RecordingConsumer) Find(filters ...Filter) []thinkingdata.Data {
+	var result []thinkingdata.Data
+	for _, d := range c.Data() {
+		if matchAll(d, filters) {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// Events get the recorded data of the event.
+func (c *RecordingConsumer) Events(eventName string) []thinkingdata.Data {
+	return c.Find(ByEventName(eventName))
+}
+
+// Len get the count of the recorded data.
+func (c *RecordingConsumer) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.data)
+}
+
+// FlushCount get how many times Flush is called.
+func (c *RecordingConsumer) FlushCount() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.flushCount
+}
+
+// Closed report whether Close is called.
+func (c *RecordingConsumer) Closed() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.closed
+}
+
+// Reset remove the recorded data, and the consumer can be used again after closed.
+func (c *RecordingConsumer) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.data = nil
+	c.flushCount = 0
+	c.closed = false
+}
+
+// Filter select the recorded data
+type Filter func(d thinkingdata.Data) bool
+
+// ByEventName select the data of the event
+func ByEventName(eventName string) Filter {
+	return func(d thinkingdata.Data) bool {
+		return d.EventName == eventName
+	}
+}
+
+// ByType select the data of the type, e.g. thinkingdata.Track, thinkingdata.UserSet
+func ByType(dataType string) Filter {
+	return func(d thinkingdata.Data) bool {
+		return d.Type == dataType
+	}
+}
+
+// ByAccountId select the data of the account id
+func ByAccountId(accountId string) Filter {
+	return func(d thinkingdata.Data) bool {
+		return d.AccountId == accountId
+	}
+}
+
+// ByDistinctId select the data of the distinct id
+func ByDistinctId(distinctId string) Filter {
+	return func(d thinkingdata.Data) bool {
+		return d.DistinctId == distinctId
+	}
+}
+
+// ByUser select the data of the user, empty id matches any value.
+func ByUser(accountId, distinctId string) Filter {
+	return func(d thinkingdata.Data) bool {
+		return (len(accountId) == 0 || d.AccountId == accountId) && (len(distinctId) == 0 || d.DistinctId == distinctId)
+	}
+}
+
+// ByProperty select the data which has the property value
+func ByProperty(key string, value interface{}) Filter {
+	return func(d thinkingdata.Data) bool {
+		v, ok := d.Properties[key]
+		return ok && valueEqual(v, value)
+	}
+}
+
+func matchAll(d thinkingdata.Data, filters []Filter) bool {
+	for _, f := range filters {
+		if !f(d) {
+			return false
+		}
+	}
+	return true
+}
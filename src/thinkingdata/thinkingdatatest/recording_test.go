@@ -0,0 +1,149 @@
+package thinkingdatatest_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata"
+	"github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata/thinkingdatatest"
+)
+
+// fakeTB record the failure of an assertion instead of failing the test.
+type fakeTB struct {
+	testing.TB
+	failure string
+}
+
+type fatal struct{}
+
+func (t *fakeTB) Helper() {}
+
+func (t *fakeTB) Fatalf(format string, args ...interface{}) {
+	t.failure = fmt.Sprintf(format, args...)
+	panic(fatal{})
+}
+
+// expectFailure run the assertions with fakeTB, and check that one of them fails.
+func expectFailure(t *testing.T, assert func(tb testing.TB)) {
+	t.Helper()
+	tb := &fakeTB{TB: t}
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				if _, ok := r.(fatal); !ok {
+					panic(r)
+				}
+			}
+		}()
+		assert(tb)
+	}()
+	if len(tb.failure) == 0 {
+		t.Error("expected the assertion to fail")
+	}
+}
+
+func TestRecordingConsumer(t *testing.T) {
+	c := thinkingdatatest.NewRecordingConsumer()
+	ta := thinkingdata.New(c)
+	super := map[string]interface{}{"channel": "store"}
+	ta.SetSuperProperties(super)
+
+	properties := map[string]interface{}{"price": 9.9, "count": 2}
+	if err := ta.Track("A1", "D1", "purchase", properties); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.UserSet("A1", "", map[string]interface{}{"level": 3}); err != nil {
+		t.Fatal(err)
+	}
+	// the recorded properties are not changed by the caller
+	properties["price"] = 0
+
+	d := thinkingdatatest.AssertEvent(t, c, "purchase")
+	thinkingdatatest.AssertUser(t, d, "A1", "D1")
+	thinkingdatatest.AssertMerged(t, d, super, map[string]interface{}{"price": 9.9, "count": 2})
+	// numbers are compared by value
+	thinkingdatatest.AssertProperty(t, d, "count", 2.0)
+	thinkingdatatest.AssertNoEvent(t, c, "refund")
+
+	thinkingdatatest.AssertCount(t, c, 2, thinkingdatatest.ByAccountId("A1"))
+	thinkingdatatest.AssertCount(t, c, 1, thinkingdatatest.ByType(thinkingdata.UserSet), thinkingdatatest.ByProperty("level", 3))
+	thinkingdatatest.AssertCount(t, c, 1, thinkingdatatest.ByUser("", "D1"))
+	thinkingdatatest.AssertCount(t, c, 0, thinkingdatatest.ByDistinctId("D2"))
+	if c.Len() != 2 || len(c.Events("purchase")) != 1 {
+		t.Errorf("got %d data, %d purchase events", c.Len(), len(c.Events("purchase")))
+	}
+
+	ta.Flush()
+	if c.FlushCount() != 1 {
+		t.Errorf("got flush count %d, want 1", c.FlushCount())
+	}
+	ta.Close()
+	if !c.Closed() {
+		t.Error("the consumer is not closed")
+	}
+	if err := c.Add(d); !errors.Is(err, thinkingdata.ErrConsumerClosed) {
+		t.Errorf("got %v, want ErrConsumerClosed", err)
+	}
+
+	c.Reset()
+	if c.Len() != 0 || c.FlushCount() != 0 || c.Closed() {
+		t.Error("the consumer is not reset")
+	}
+}
+
+func TestRecordingConsumerError(t *testing.T) {
+	c := thinkingdatatest.NewRecordingConsumer()
+	ta := thinkingdata.New(c)
+	injected := errors.New("injected")
+
+	c.SetError(injected)
+	if err := ta.Track("A1", "", "purchase", nil); !errors.Is(err, injected) {
+		t.Fatalf("got %v, want the injected error", err)
+	}
+	c.SetError(nil)
+	if err := ta.Track("A1", "", "purchase", nil); err != nil {
+		t.Fatal(err)
+	}
+	thinkingdatatest.AssertCount(t, c, 1)
+}
+
+func TestStringentRecordingConsumer(t *testing.T) {
+	c := thinkingdatatest.NewStringentRecordingConsumer()
+	ta := thinkingdata.New(c)
+
+	// the property key is checked strictly like TDDebugConsumer
+	if err := ta.Track("A1", "", "purchase", map[string]interface{}{"1invalid": 1}); !errors.Is(err, thinkingdata.ErrInvalidPropertyKey) {
+		t.Fatalf("got %v, want ErrInvalidPropertyKey", err)
+	}
+	thinkingdatatest.AssertCount(t, c, 0)
+
+	c.SetStringent(false)
+	if err := ta.Track("A1", "", "purchase", map[string]interface{}{"1invalid": 1}); err != nil {
+		t.Fatal(err)
+	}
+	thinkingdatatest.AssertCount(t, c, 1)
+}
+
+func TestAssertionFailures(t *testing.T) {
+	c := thinkingdatatest.NewRecordingConsumer()
+	ta := thinkingdata.New(c)
+	ta.Track("A1", "", "purchase", map[string]interface{}{"price": 9.9, "tags": []string{"a"}})
+	ta.Track("A1", "", "login", nil)
+	ta.Track("A1", "", "login", nil)
+	d := c.Events("purchase")[0]
+
+	expectFailure(t, func(tb testing.TB) { thinkingdatatest.AssertCount(tb, c, 1) })
+	expectFailure(t, func(tb testing.TB) { thinkingdatatest.AssertEvent(tb, c, "refund") })
+	expectFailure(t, func(tb testing.TB) { thinkingdatatest.AssertEvent(tb, c, "login") })
+	expectFailure(t, func(tb testing.TB) { thinkingdatatest.AssertNoEvent(tb, c, "login") })
+	expectFailure(t, func(tb testing.TB) { thinkingdatatest.AssertUser(tb, d, "A2", "") })
+	expectFailure(t, func(tb testing.TB) { thinkingdatatest.AssertProperty(tb, d, "price", 9.8) })
+	expectFailure(t, func(tb testing.TB) { thinkingdatatest.AssertProperty(tb, d, "price", "9.9") })
+	expectFailure(t, func(tb testing.TB) { thinkingdatatest.AssertProperty(tb, d, "tags", []string{"b"}) })
+	expectFailure(t, func(tb testing.TB) { thinkingdatatest.AssertProperty(tb, d, "missing", 1) })
+	expectFailure(t, func(tb testing.TB) { thinkingdatatest.AssertNoProperty(tb, d, "price") })
+	expectFailure(t, func(tb testing.TB) {
+		thinkingdatatest.AssertMerged(tb, d, map[string]interface{}{"price": 1}, map[string]interface{}{"tags": []string{"a"}})
+	})
+}
@@ -1,10 +1,9 @@
 package thinkingdata
 
 import (
-	"errors"
-	"fmt"
+	"bytes"
+	"encoding/json"
 	"github.com/google/uuid"
-	"os"
 	"reflect"
 	"regexp"
 	"time"
@@ -17,7 +16,6 @@ const (
 
 // A string of 50 letters and digits that starts with '#' or a letter
 var keyPattern, _ = regexp.Compile(KEY_PATTERN)
-var timeRegexp = regexp.MustCompile(`"((\d{4}-\d{2}-\d{2})T(\d{2}:\d{2}:\d{2})(?:\.(\d{3}))\d*)(Z|[\+-]\d{2}:\d{2})"`)
 
 func mergeProperties(target, source map[string]interface{}) {
 	for k, v := range source {
@@ -25,28 +23,177 @@ func mergeProperties(target, source map[string]interface{}) {
 	}
 }
 
-func extractTime(p map[string]interface{}) string {
-	if t, ok := p["#time"]; ok {
+// decodeData decode a line written by TDLogConsumer, numbers are kept as json.Number to avoid losing precision.
+func decodeData(line []byte) (Data, error) {
+	var d Data
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	err := decoder.Decode(&d)
+	return d, err
+}
+
+// extractTime get "#time" in properties, the current time is used when not found. The time is converted to loc
+// if loc is not nil. The parsed time is returned as well, it's zero if "#time" is a string in unknown format.
+func extractTime(p map[string]interface{}, loc *time.Location) (string, time.Time) {
+	t := time.Now()
+	if v, ok := p["#time"]; ok {
 		delete(p, "#time")
-		switch v := t.(type) {
+		switch v := v.(type) {
 		case string:
-			return v
+			// the string is regarded as a time in loc
+			parseLoc := loc
+			if parseLoc == nil {
+				parseLoc = time.Local
+			}
+			parsed, err := time.ParseInLocation(DATE_FORMAT, v, parseLoc)
+			if err != nil {
+				parsed, err = time.ParseInLocation("2006-01-02 15:04:05", v, parseLoc)
+			}
+			if err != nil {
+				return v, time.Time{}
+			}
+			return v, parsed
 		case time.Time:
-			return v.Format(DATE_FORMAT)
-		default:
-			return time.Now().Format(DATE_FORMAT)
+			t = v
 		}
 	}
+	t = inLocation(t, loc)
+	return t.Format(DATE_FORMAT), t
+}
 
-	return time.Now().Format(DATE_FORMAT)
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	if loc == nil {
+		return t
+	}
+	return t.In(loc)
+}
+
+// localizeTimes convert the time.Time values at any depth of maps, slices, arrays, structs and pointers to loc.
+// The containers with times are copied with the same types, so that the properties of the caller are not changed.
+// The values encoded by json.Marshaler or encoding.TextMarshaler are kept as they are.
+func localizeTimes(v interface{}, loc *time.Location) (interface{}, bool) {
+	if v == nil {
+		return v, false
+	}
+	converted, ok := localizeValue(reflect.ValueOf(v), loc, make(map[uintptr]bool))
+	if !ok {
+		return v, false
+	}
+	return converted.Interface(), true
+}
+
+// localizeValue return a copy of rv with the times converted to loc, ok is false when there is no time in rv.
+// visited holds the pointers, maps and slices on the current path, a cyclic value is not followed.
+func localizeValue(rv reflect.Value, loc *time.Location, visited map[uintptr]bool) (reflect.Value, bool) {
+	if rv.Type() == timeType {
+		return reflect.ValueOf(rv.Interface().(time.Time).In(loc)), true
+	}
+	if rv.Type().Implements(jsonMarshalerType) || rv.Type().Implements(textMarshalerType) {
+		return rv, false
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.IsNil() {
+			return rv, false
+		}
+		elem, ok := localizeValue(rv.Elem(), loc, visited)
+		if !ok {
+			return rv, false
+		}
+		result := reflect.New(rv.Type()).Elem()
+		result.Set(elem)
+		return result, true
+	case reflect.Ptr:
+		if rv.IsNil() || visited[rv.Pointer()] {
+			return rv, false
+		}
+		visited[rv.Pointer()] = true
+		defer delete(visited, rv.Pointer())
+		elem, ok := localizeValue(rv.Elem(), loc, visited)
+		if !ok {
+			return rv, false
+		}
+		result := reflect.New(rv.Type().Elem())
+		result.Elem().Set(elem)
+		return result, true
+	case reflect.Map:
+		if rv.IsNil() || visited[rv.Pointer()] {
+			return rv, false
+		}
+		visited[rv.Pointer()] = true
+		defer delete(visited, rv.Pointer())
+		var result reflect.Value
+		iter := rv.MapRange()
+		for iter.Next() {
+			item, ok := localizeValue(iter.Value(), loc, visited)
+			if !ok {
+				continue
+			}
+			if !result.IsValid() {
+				result = reflect.MakeMapWithSize(rv.Type(), rv.Len())
+				copyIter := rv.MapRange()
+				for copyIter.Next() {
+					result.SetMapIndex(copyIter.Key(), copyIter.Value())
+				}
+			}
+			result.SetMapIndex(iter.Key(), item)
+		}
+		return result, result.IsValid()
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice {
+			if rv.IsNil() || visited[rv.Pointer()] {
+				return rv, false
+			}
+			visited[rv.Pointer()] = true
+			defer delete(visited, rv.Pointer())
+		}
+		var result reflect.Value
+		for i := 0; i < rv.Len(); i++ {
+			item, ok := localizeValue(rv.Index(i), loc, visited)
+			if !ok {
+				continue
+			}
+			if !result.IsValid() {
+				if rv.Kind() == reflect.Slice {
+					result = reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+					reflect.Copy(result, rv)
+				} else {
+					result = reflect.New(rv.Type()).Elem()
+					result.Set(rv)
+				}
+			}
+			result.Index(i).Set(item)
+		}
+		return result, result.IsValid()
+	case reflect.Struct:
+		var result reflect.Value
+		for i := 0; i < rv.NumField(); i++ {
+			if len(rv.Type().Field(i).PkgPath) > 0 {
+				// unexported fields are not encoded
+				continue
+			}
+			item, ok := localizeValue(rv.Field(i), loc, visited)
+			if !ok {
+				continue
+			}
+			if !result.IsValid() {
+				result = reflect.New(rv.Type()).Elem()
+				result.Set(rv)
+			}
+			result.Field(i).Set(item)
+		}
+		return result, result.IsValid()
+	}
+	return rv, false
 }
 
-func extractStringProperty(p map[string]interface{}, key string) string {
+func extractStringProperty(p map[string]interface{}, key string, logger *instanceLogger) string {
 	if t, ok := p[key]; ok {
 		delete(p, key)
 		v, ok := t.(string)
 		if !ok {
-			fmt.Fprintln(os.Stderr, "Invalid data type for "+key)
+			logger.warning("Invalid data type for %s: %T", key, t)
 		}
 		return v
 	}
@@ -64,13 +211,15 @@ func isNotNumber(v interface{}) bool {
 }
 
 func formatProperties(d *Data, ta *TDAnalytics) error {
+	loc := ta.GetTimeLocation()
+	logger := ta.log()
 
 	if d.EventName != "" {
 		matched := checkPattern([]byte(d.EventName))
 		if !matched {
-			msg := "invalid event name: " + d.EventName
-			tdLogInfo(msg)
-			return errors.New(msg)
+			err := &ValidationError{Key: "#event_name", Value: d.EventName, Reason: d.EventName, Err: ErrInvalidEventName}
+			logger.with(logAttr(LogKeyEventName, d.EventName), logAttr(LogKeyError, err)).info(err.Error())
+			return err
 		}
 	}
 
@@ -79,16 +228,16 @@ func formatProperties(d *Data, ta *TDAnalytics) error {
 			if ta.consumer.IsStringent() {
 				isMatch := checkPattern([]byte(k))
 				if !isMatch {
-					msg := "invalid property key: " + k
-					tdLogInfo(msg)
-					return errors.New(msg)
+					err := &ValidationError{Key: k, Value: v, Reason: k, Err: ErrInvalidPropertyKey}
+					logger.with(logAttr(LogKeyEventName, d.EventName), logAttr(LogKeyError, err)).info(err.Error())
+					return err
 				}
 			}
 
 			if d.Type == UserAdd && isNotNumber(v) {
-				msg := "invalid property value: only numbers is supported by UserAdd"
-				tdLogInfo(msg)
-				return errors.New(msg)
+				err := &ValidationError{Key: k, Value: v, Reason: "only numbers is supported by UserAdd", Err: ErrInvalidPropertyValue}
+				logger.with(logAttr(LogKeyEventName, d.EventName), logAttr(LogKeyError, err)).info(err.Error())
+				return err
 			}
 
 			// check value
@@ -98,11 +247,16 @@ func formatProperties(d *Data, ta *TDAnalytics) error {
 			case float64:
 			case string:
 			case time.Time:
-				d.Properties[k] = v.(time.Time).Format(DATE_FORMAT)
+				d.Properties[k] = inLocation(v.(time.Time), loc).Format(DATE_FORMAT)
 			case []string:
 				d.IsComplex = true
 			default:
 				d.IsComplex = true
+				if loc != nil {
+					if converted, ok := localizeTimes(v, loc); ok {
+						d.Properties[k] = converted
+					}
+				}
 			}
 		}
 	}
@@ -125,14 +279,6 @@ func checkPattern(name []byte) bool {
 	return keyPattern.Match(name)
 }
 
-func parseTime(input []byte) string {
-	var substitution = "\"$2 $3.$4\""
-	for timeRegexp.Match(input) {
-		input = timeRegexp.ReplaceAll(input, []byte(substitution))
-	}
-	return string(input)
-}
-
 func generateUUID() string {
 	newUUID, err := uuid.NewUUID()
 	if err != nil {
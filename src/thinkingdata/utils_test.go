@@ -0,0 +1,58 @@
+package thinkingdata
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalizeTimes(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	properties := map[string]interface{}{
+		"time":   now,
+		"nested": map[string]interface{}{"time": now},
+		"list":   []time.Time{now},
+		"struct": struct{ At time.Time }{At: now},
+	}
+
+	converted, ok := localizeTimes(properties, loc)
+	if !ok {
+		t.Fatal("expected the times to be localized")
+	}
+	m := converted.(map[string]interface{})
+	for _, got := range []time.Time{
+		m["time"].(time.Time),
+		m["nested"].(map[string]interface{})["time"].(time.Time),
+		m["list"].([]time.Time)[0],
+		m["struct"].(struct{ At time.Time }).At,
+	} {
+		if got.Location() != loc || !got.Equal(now) {
+			t.Errorf("got %v, want %v in %v", got, now, loc)
+		}
+	}
+	if properties["time"].(time.Time).Location() != time.UTC {
+		t.Error("the properties of the caller are changed")
+	}
+}
+
+func TestLocalizeTimesCyclic(t *testing.T) {
+	now := time.Now()
+	cyclicMap := map[string]interface{}{"time": now}
+	cyclicMap["self"] = cyclicMap
+	cyclicList := []interface{}{now, nil}
+	cyclicList[1] = cyclicList
+	var cyclicInterface interface{}
+	cyclicInterface = &cyclicInterface
+
+	for name, v := range map[string]interface{}{
+		"map":       cyclicMap,
+		"slice":     cyclicList,
+		"interface": cyclicInterface,
+		"nested":    map[string]interface{}{"m": cyclicMap, "l": cyclicList},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// must return instead of overflowing the stack, the cycle is reported by the encoder later
+			localizeTimes(v, time.UTC)
+		})
+	}
+}